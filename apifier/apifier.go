@@ -0,0 +1,432 @@
+package apifier
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/emoji"
+	"github.com/leporo/sqlf"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var db *sql.DB
+var fileSaveWaitGroup sync.WaitGroup
+var fileSaveErrorMutex sync.Mutex
+var fileSaveError error
+
+const JSON_PAGINATION_PAGE_SIZE = 500
+
+// Used if the fetcher hasn't recorded its threshold yet
+const DEFAULT_MINIMUM_REPOSITORY_STARGAZERS = 5
+
+// The fetcher saves its -minimum-stars under this key in the State table after every complete sweep
+const MINIMUM_STARS_STATE_KEY = "minimum_stars"
+
+// The star threshold of the current export
+var minimumStargazers int64
+
+// When the export happens - fixed in tests, so exports can be compared with golden files
+var timeNow = time.Now
+
+type closable interface {
+	Close() error
+}
+
+func closeOrPanic(toClose closable) {
+	err := toClose.Close()
+	if err != nil {
+		log.Panicln("Could not call .Close(): ", err)
+	}
+}
+
+func programmingLanguages() []string {
+	// Query to retrieve unique languages
+	// This query is done on "Repo" instead of "ActiveRepo" to be sure to override the result json file for every
+	// programming language we've ever came across.
+	query := "SELECT DISTINCT Language FROM Repo"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Panicln("Error executing query:", err)
+	}
+	defer closeOrPanic(rows)
+
+	var languages []string
+	for rows.Next() {
+		var language string
+		if err := rows.Scan(&language); err != nil {
+			log.Panicln("Error scanning row:", err)
+		}
+		languages = append(languages, language)
+	}
+	return languages
+}
+
+// fetcherMinimumStars returns the threshold of the fetcher's last complete sweep - repositories with fewer
+// stars are not kept up to date
+func fetcherMinimumStars() int64 {
+	var value string
+	err := db.QueryRow("SELECT Value FROM State WHERE Name = ?", MINIMUM_STARS_STATE_KEY).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DEFAULT_MINIMUM_REPOSITORY_STARGAZERS
+	} else if err != nil {
+		log.Panicln("Could not read the fetcher's minimum stars:", err)
+	}
+
+	var stars int64
+	if err := json.Unmarshal([]byte(value), &stars); err != nil {
+		log.Panicln("Could not parse the fetcher's minimum stars:", err)
+	}
+	return stars
+}
+
+func chooseMinimumStargazers() int64 {
+	fetcherStars := fetcherMinimumStars()
+	if config.MinimumStars == 0 {
+		return fetcherStars
+	}
+
+	if config.MinimumStars < fetcherStars {
+		log.Printf("Warning: exporting repositories with %d stars and up, but the fetcher only keeps ones with %d and up updated\n", config.MinimumStars, fetcherStars)
+	}
+	return config.MinimumStars
+}
+
+// createView creates a view of repos with fields actually used on the frontend.
+// additionally, depending on config.StalePolicy, repos not found recently enough are left out or marked
+// as stale - this prevents displaying deleted repositories.
+func createActiveRepoView() {
+	// Have to use sprintf here, because as sqlite points out: "parameters are not allowed in views"
+	_, err := db.Exec(fmt.Sprintf(`
+		begin transaction;
+		drop view if exists ActiveRepo;
+		create view ActiveRepo as
+		    select
+		        Id,
+		        Archived,
+		        CreatedAt,
+		        Description,
+		        GithubLink,
+		        Homepage,
+		        Language,
+		        LicenseSpdxId,
+		        LicenseName,
+		        Repo.Name,
+		        OwnerAvatarUrl,
+		        OwnerLogin,
+		        RepoPushedAt,
+		        RepoUpdatedAt,
+		        Stargazers,
+		        Forks,
+		        Watchers,
+		        Size,
+		        Fork,
+		        IsTemplate,
+		        DefaultBranch,
+		        HasPages,
+		        Visibility,
+		        RepoRelease.TagName as LatestRelease,
+		        RepoRelease.PublishedAt as LatestReleasePublishedAt,
+		        (select Count from RepoContributors where RepoId = Repo.Id) as Contributors,
+		        (select json_group_object(Language, Bytes) from (select Language, Bytes from RepoLanguage where RepoId = Repo.Id order by Bytes desc)) as Languages,
+		        %s as Stale
+		    from Repo
+		    left join RepoRelease on RepoRelease.RepoId = Repo.Id
+		    where %s;
+		end;
+	`, staleCondition(), activeRepoFilter()))
+	if err != nil {
+		log.Panicln("Could not create the ActiveRepo view:", err)
+	}
+}
+
+func createIndices() {
+	log.Print("Creating index on Repo(Language, Stargazers, Id, LastSeenCycle)... ")
+	_, err := db.Exec(`
+		create index if not exists LanguageStargazersId on Repo(Language, Stargazers DESC, Id, LastSeenCycle);
+	`)
+	if err != nil {
+		log.Panicln("\nCould not create index LanguageStargazers:", err)
+	}
+	log.Println("done")
+
+	log.Print("Creating index on Repo(Stargazers, Id, LastSeenCycle)... ")
+	_, err = db.Exec(`
+		create index if not exists StargazersId on Repo(Stargazers DESC, Id, LastSeenCycle);
+	`)
+	if err != nil {
+		log.Panicln("\nCould not create index Stargazers:", err)
+	}
+	log.Println("done")
+}
+
+func dropIndices() {
+	log.Print("Dropping index on Repo(Language, Stargazers, Id)... ")
+	_, err := db.Exec(`drop index LanguageStargazersId;`)
+	if err != nil {
+		log.Panicln("\nCould not drop index LanguageStargazersId", err)
+	}
+	log.Println("done")
+
+	log.Print("Dropping index on Repo(Stargazers, Id)... ")
+	_, err = db.Exec(`drop index StargazersId;`)
+	if err != nil {
+		log.Panicln("\nCould not drop index StargazersId", err)
+	}
+	log.Println("done")
+}
+
+func escapeLanguageName(name string) string {
+	name = strings.ReplaceAll(name, "/", "-")
+	name = strings.ReplaceAll(name, " ", "-")
+	name = strings.ReplaceAll(name, "&", "-")
+	name = strings.ReplaceAll(name, "?", "-")
+	name = strings.ReplaceAll(name, "#", "-sharp-")
+	if name == "" {
+		return "-empty-"
+	}
+	return name
+}
+
+// Run exports the repositories from the database as paginated, gzipped json files in config.OutputDir.
+// Only one Run can happen at a time.
+func Run(ctx context.Context, cfg Config) (err error) {
+	config = cfg
+	fileSaveError = nil
+
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if caught := recover(); caught != nil {
+			err = fmt.Errorf("apifier: %v", caught)
+		}
+	}()
+
+	// Open a connection to the SQLite database
+	db, err = sql.Open("sqlite3", fmt.Sprintf("%s?mode=rw&_busy_timeout=-5000&_journal_mode=WAL", config.DatabasePath))
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer closeOrPanic(db)
+
+	minimumStargazers = chooseMinimumStargazers()
+	log.Printf("Exporting repositories with at least %d stars\n", minimumStargazers)
+	fetcherCycle = readFetcherCycle()
+
+	countStaleRepos()
+	createActiveRepoView()
+	createIndices()
+	defer dropIndices()
+
+	saveMetadata()
+	saveRedirects()
+
+	// Retrieve all possible languages from the Repo table
+	languages := programmingLanguages()
+
+	// A hack: GitHub tags vimscript as three separate language names: "Vim Script", "Vim script", and "VimL"
+	// - pretend they're the same thing
+	githubLanguageNamesForVimScript := []string{"Vim Script", "Vim script", "VimL"}
+	exportForLanguage("Vim Script / VimL", githubLanguageNamesForVimScript)
+
+	for _, language := range languages {
+		if ctx.Err() != nil {
+			break
+		}
+		if slices.Contains(githubLanguageNamesForVimScript, language) {
+			break
+		}
+		exportForLanguage(language, []string{language})
+	}
+
+	if ctx.Err() == nil {
+		exportForAll()
+	}
+
+	if ctx.Err() == nil {
+		exportRemoved()
+	}
+
+	fileSaveWaitGroup.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fileSaveError
+}
+
+func exportForAll() {
+	// Set the page size and initialize the offset
+	pageSize := JSON_PAGINATION_PAGE_SIZE
+	offset := 0
+	page := 1
+
+	for retrieveAndSaveAll(pageSize, offset, page) {
+		// Update offset and page number
+		offset += pageSize
+		page++
+	}
+}
+
+func exportForLanguage(language string, githubNamesForTheLanguage []string) {
+	// Set the page size and initialize the offset
+	pageSize := JSON_PAGINATION_PAGE_SIZE
+	offset := 0
+	page := 1
+
+	for retrieveAndSaveByLanguage(pageSize, offset, page, language, githubNamesForTheLanguage) {
+		// Update offset and page number
+		offset += pageSize
+		page++
+	}
+}
+
+// emojify renders all repository description emojis into unicode emojis
+// For example: turns Description=":rocket: LGTM" into Description="🚀 LGTM"
+func emojify(repos []ExportedRepo) []ExportedRepo {
+	for i, repo := range repos {
+		if repo.Description == nil {
+			continue
+		}
+
+		description := emoji.ReplaceAliases(*repo.Description)
+		repos[i].Description = &description
+	}
+	return repos
+}
+
+func retrieveAndSaveAll(pageSize int, offset int, page int) (shouldContinue bool) {
+	// Retrieve data from the database with pagination
+	rows, err := db.Query(`
+		SELECT `+exportedColumnList()+` FROM ActiveRepo
+		ORDER BY Stargazers DESC, Id
+		LIMIT $1 OFFSET $2
+	`, pageSize, offset)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer closeOrPanic(rows)
+
+	fileName := fmt.Sprintf("all/%d", page)
+
+	records := scanExportedRepos(rows)
+	records = emojify(records)
+
+	saveReposInBackground(fileName, records)
+
+	// Break the loop if there are no more records
+	shouldContinue = len(records) >= pageSize
+
+	return shouldContinue
+}
+
+func stringSliceToAnySlice(s []string) []any {
+	ret := make([]interface{}, len(s))
+	for i := range s {
+		ret[i] = s[i]
+	}
+	return ret
+}
+
+func retrieveAndSaveByLanguage(pageSize int, offset int, page int, language string, githubNamesForTheLanguage []string) (shouldContinue bool) {
+	fileName := fmt.Sprintf("language/%s/%d", escapeLanguageName(language), page)
+
+	records := make([]ExportedRepo, 0, pageSize)
+
+	err := sqlf.From("ActiveRepo").
+		Select(exportedColumnList()).
+		Where("Language").
+		In(stringSliceToAnySlice(githubNamesForTheLanguage)...).
+		OrderBy("Stargazers DESC, Id").
+		Limit(pageSize).
+		Offset(offset).
+		QueryAndClose(context.Background(), db, func(row *sql.Rows) {
+			records = append(records, scanExportedRepo(row))
+		})
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	records = emojify(records)
+
+	saveReposInBackground(fileName, records)
+
+	// Break the loop if there are no more records
+	shouldContinue = len(records) >= pageSize
+
+	return shouldContinue
+}
+
+// saveInBackground runs save in a new goroutine. If it fails, the error is returned from Run after all
+// the other files are saved.
+func saveInBackground(save func()) {
+	fileSaveWaitGroup.Add(1)
+	go func() {
+		defer fileSaveWaitGroup.Done()
+		defer func() {
+			if caught := recover(); caught != nil {
+				fileSaveErrorMutex.Lock()
+				defer fileSaveErrorMutex.Unlock()
+				if fileSaveError == nil {
+					fileSaveError = fmt.Errorf("could not save a file: %v", caught)
+				}
+			}
+		}()
+		save()
+	}()
+}
+
+// saveReposInBackground saves a page of repos in every published format - the original one in
+// config.OutputDir, and v2 in config.OutputDir/v2
+func saveReposInBackground(fileName string, records []ExportedRepo) {
+	saveInBackground(func() { saveToFile(filepath.Join(config.OutputDir, fileName), records) })
+	saveInBackground(func() { saveToFile(filepath.Join(config.OutputDir, "v2", fileName), exportV2(records)) })
+}
+
+func saveToFile(fileName string, records any) {
+	// Convert records to JSON
+	jsonData, err := json.Marshal(records)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	// Write JSON data to a file
+	saveDataToGzipFile(fileName, jsonData)
+}
+
+func saveDataToGzipFile(fileName string, data []byte) {
+	err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm)
+	if err != nil {
+		log.Panicf("Could not create directory %v: %v\n", filepath.Dir(fileName), err)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer closeOrPanic(file)
+
+	gzipWriter := gzip.NewWriter(file)
+	defer closeOrPanic(gzipWriter)
+
+	// Write data to the gzip file
+	_, err = gzipWriter.Write(data)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	log.Printf("Created file '%s'\n", fileName)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/karolba/top-of-github/apifier"
+)
+
+func main() {
+	config := apifier.DefaultConfig()
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	err := apifier.Run(context.Background(), config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
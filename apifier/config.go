@@ -0,0 +1,67 @@
+package apifier
+
+import (
+	"errors"
+	"flag"
+	"time"
+)
+
+// Config is everything an apifier run can be configured with
+type Config struct {
+	OutputDir    string
+	DatabasePath string
+
+	// Only repositories with at least this many stars are exported. 0 means the fetcher's threshold.
+	MinimumStars int64
+
+	// Repos not seen by the fetcher for more than MaxNotSeenCycles sweeps (negative: no limit), or not
+	// fetched for longer than MaxAge (zero: no limit) are stale. They're excluded or flagged, per StalePolicy.
+	MaxNotSeenCycles int64
+	MaxAge           time.Duration
+	StalePolicy      string
+
+	// How far back removed/N lists repositories that disappeared from GitHub
+	RemovedWindow time.Duration
+
+	// Publish forks, watchers, size, the latest release, etc. in v2 too
+	ExportExtraFields bool
+	// Leave out forks and template repositories
+	ExcludeForks     bool
+	ExcludeTemplates bool
+}
+
+// The config of the currently running apifier
+var config = DefaultConfig()
+
+func DefaultConfig() Config {
+	return Config{
+		OutputDir:    ".",
+		DatabasePath: "state/repos.db",
+
+		MaxNotSeenCycles: 15,
+		StalePolicy:      STALE_POLICY_EXCLUDE,
+
+		RemovedWindow: 90 * 24 * time.Hour,
+	}
+}
+
+// RegisterFlags binds the config to command line flags
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.OutputDir, "output-dir", c.OutputDir, "Where to save generated json files")
+	flags.StringVar(&c.DatabasePath, "database", c.DatabasePath, "Path to the sqlite database to use")
+	flags.Int64Var(&c.MinimumStars, "minimum-stars", c.MinimumStars, "Export only repositories with this many stars and up - for example, for a slimmer dataset. Defaults to the fetcher's -minimum-stars")
+	flags.Int64Var(&c.MaxNotSeenCycles, "max-not-seen-cycles", c.MaxNotSeenCycles, "Repositories not found by this many fetcher sweeps in a row are stale. Negative to disable")
+	flags.DurationVar(&c.MaxAge, "max-age", c.MaxAge, "Repositories not fetched from GitHub for this long are stale. Zero to disable")
+	flags.StringVar(&c.StalePolicy, "stale-policy", c.StalePolicy, "What to do with stale repositories: 'exclude' them, or 'flag' them with Stale: true (in v2)")
+	flags.DurationVar(&c.RemovedWindow, "removed-window", c.RemovedWindow, "How far back to list removed repositories in removed/N")
+	flags.BoolVar(&c.ExportExtraFields, "extra-fields", c.ExportExtraFields, "Also publish Forks, Watchers, Size, Fork, IsTemplate, DefaultBranch, HasPages, Visibility, LatestRelease, LatestReleasePublishedAt, Contributors and Languages (in v2)")
+	flags.BoolVar(&c.ExcludeForks, "exclude-forks", c.ExcludeForks, "Don't publish repositories which are forks")
+	flags.BoolVar(&c.ExcludeTemplates, "exclude-templates", c.ExcludeTemplates, "Don't publish template repositories")
+}
+
+func (c *Config) validate() error {
+	if c.StalePolicy != STALE_POLICY_EXCLUDE && c.StalePolicy != STALE_POLICY_FLAG {
+		return errors.New("the stale policy has to be either 'exclude' or 'flag'")
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package apifier
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// EXPORT_SCHEMA_VERSION is published in metadata. Bump it with every change to ExportedRepo.
+const EXPORT_SCHEMA_VERSION = 1
+
+// ExportedRepo is a repository as published in the JSON API. It's the contract with the frontend (mirrored
+// by Repository in frontend/src/apitypes.ts), so it's defined here instead of following fetcher's tables.
+// Fields are in alphabetical order, like they were when records were json maps.
+type ExportedRepo struct {
+	Archived       *int64     `json:"Archived"`
+	CreatedAt      *time.Time `json:"CreatedAt"`
+	Description    *string    `json:"Description"`
+	GithubLink     *string    `json:"GithubLink"`
+	Homepage       *string    `json:"Homepage"`
+	Id             int64      `json:"Id"`
+	Language       *string    `json:"Language"`
+	LicenseName    *string    `json:"LicenseName"`
+	LicenseSpdxId  *string    `json:"LicenseSpdxId"`
+	Name           *string    `json:"Name"`
+	OwnerAvatarUrl *string    `json:"OwnerAvatarUrl"`
+	OwnerLogin     *string    `json:"OwnerLogin"`
+	RepoPushedAt   *time.Time `json:"RepoPushedAt"`
+	RepoUpdatedAt  *time.Time `json:"RepoUpdatedAt"`
+	Stargazers     int64      `json:"Stargazers"`
+
+	// Only published in v2 - see config.StalePolicy
+	Stale bool `json:"-"`
+
+	// Only published in v2, with config.ExportExtraFields. Missing for repos not fetched since they were added.
+	Forks         *int64  `json:"-"`
+	Watchers      *int64  `json:"-"`
+	Size          *int64  `json:"-"`
+	Fork          *bool   `json:"-"`
+	IsTemplate    *bool   `json:"-"`
+	DefaultBranch *string `json:"-"`
+	HasPages      *bool   `json:"-"`
+	Visibility    *string `json:"-"`
+
+	// Also with config.ExportExtraFields - filled in by the fetcher's enrichment worker, missing until it gets
+	// to a repository. Languages is a json object of bytes of code per language.
+	LatestRelease            *string    `json:"-"`
+	LatestReleasePublishedAt *time.Time `json:"-"`
+	Contributors             *int64     `json:"-"`
+	Languages                *string    `json:"-"`
+}
+
+// exportedColumns are the columns of ActiveRepo read into an ExportedRepo, in the order scanExportedRepo
+// expects them
+var exportedColumns = []string{
+	"Archived",
+	"CreatedAt",
+	"Description",
+	"GithubLink",
+	"Homepage",
+	"Id",
+	"Language",
+	"LicenseName",
+	"LicenseSpdxId",
+	"Name",
+	"OwnerAvatarUrl",
+	"OwnerLogin",
+	"RepoPushedAt",
+	"RepoUpdatedAt",
+	"Stargazers",
+	"Stale",
+	"Forks",
+	"Watchers",
+	"Size",
+	"Fork",
+	"IsTemplate",
+	"DefaultBranch",
+	"HasPages",
+	"Visibility",
+	"LatestRelease",
+	"LatestReleasePublishedAt",
+	"Contributors",
+	"Languages",
+}
+
+func exportedColumnList() string {
+	return strings.Join(exportedColumns, ", ")
+}
+
+func scanExportedRepo(row *sql.Rows) ExportedRepo {
+	var repo ExportedRepo
+	err := row.Scan(
+		&repo.Archived,
+		&repo.CreatedAt,
+		&repo.Description,
+		&repo.GithubLink,
+		&repo.Homepage,
+		&repo.Id,
+		&repo.Language,
+		&repo.LicenseName,
+		&repo.LicenseSpdxId,
+		&repo.Name,
+		&repo.OwnerAvatarUrl,
+		&repo.OwnerLogin,
+		&repo.RepoPushedAt,
+		&repo.RepoUpdatedAt,
+		&repo.Stargazers,
+		&repo.Stale,
+		&repo.Forks,
+		&repo.Watchers,
+		&repo.Size,
+		&repo.Fork,
+		&repo.IsTemplate,
+		&repo.DefaultBranch,
+		&repo.HasPages,
+		&repo.Visibility,
+		&repo.LatestRelease,
+		&repo.LatestReleasePublishedAt,
+		&repo.Contributors,
+		&repo.Languages,
+	)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return repo
+}
+
+func scanExportedRepos(rows *sql.Rows) []ExportedRepo {
+	repos := []ExportedRepo{}
+	for rows.Next() {
+		repos = append(repos, scanExportedRepo(rows))
+	}
+	if err := rows.Err(); err != nil {
+		log.Panicln(err)
+	}
+	return repos
+}
+
+// EXPORT_SCHEMA_VERSION_V2 is published in v2/metadata. Bump it with every change to ExportedRepoV2.
+const EXPORT_SCHEMA_VERSION_V2 = 5
+
+// ExportedRepoV2 is how repositories are published under v2/: with real booleans, RFC 3339 UTC timestamps,
+// and null for every missing value - empty strings included
+type ExportedRepoV2 struct {
+	Archived       bool    `json:"Archived"`
+	CreatedAt      *string `json:"CreatedAt"`
+	Description    *string `json:"Description"`
+	GithubLink     string  `json:"GithubLink"`
+	Homepage       *string `json:"Homepage"`
+	Id             int64   `json:"Id"`
+	Language       *string `json:"Language"`
+	LicenseName    *string `json:"LicenseName"`
+	LicenseSpdxId  *string `json:"LicenseSpdxId"`
+	Name           string  `json:"Name"`
+	OwnerAvatarUrl string  `json:"OwnerAvatarUrl"`
+	OwnerLogin     string  `json:"OwnerLogin"`
+	RepoPushedAt   *string `json:"RepoPushedAt"`
+	RepoUpdatedAt  *string `json:"RepoUpdatedAt"`
+	Stargazers     int64   `json:"Stargazers"`
+	Stale          bool    `json:"Stale"`
+
+	// Only with config.ExportExtraFields
+	Forks         *int64  `json:"Forks,omitempty"`
+	Watchers      *int64  `json:"Watchers,omitempty"`
+	Size          *int64  `json:"Size,omitempty"`
+	Fork          *bool   `json:"Fork,omitempty"`
+	IsTemplate    *bool   `json:"IsTemplate,omitempty"`
+	DefaultBranch *string `json:"DefaultBranch,omitempty"`
+	HasPages      *bool   `json:"HasPages,omitempty"`
+	Visibility    *string `json:"Visibility,omitempty"`
+
+	LatestRelease            *string         `json:"LatestRelease,omitempty"`
+	LatestReleasePublishedAt *string         `json:"LatestReleasePublishedAt,omitempty"`
+	Contributors             *int64          `json:"Contributors,omitempty"`
+	Languages                json.RawMessage `json:"Languages,omitempty"`
+}
+
+// nullIfEmpty treats empty strings as missing
+func nullIfEmpty(s *string) *string {
+	if s == nil || *s == "" {
+		return nil
+	}
+	return s
+}
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// rfc3339 formats a timestamp in UTC. Zero times are what xorm saves for missing ones.
+func rfc3339(t *time.Time) *string {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	formatted := t.UTC().Format(time.RFC3339)
+	return &formatted
+}
+
+func (repo ExportedRepo) v2() ExportedRepoV2 {
+	repoV2 := ExportedRepoV2{
+		Archived:       repo.Archived != nil && *repo.Archived != 0,
+		CreatedAt:      rfc3339(repo.CreatedAt),
+		Description:    nullIfEmpty(repo.Description),
+		GithubLink:     valueOrEmpty(repo.GithubLink),
+		Homepage:       nullIfEmpty(repo.Homepage),
+		Id:             repo.Id,
+		Language:       nullIfEmpty(repo.Language),
+		LicenseName:    nullIfEmpty(repo.LicenseName),
+		LicenseSpdxId:  nullIfEmpty(repo.LicenseSpdxId),
+		Name:           valueOrEmpty(repo.Name),
+		OwnerAvatarUrl: valueOrEmpty(repo.OwnerAvatarUrl),
+		OwnerLogin:     valueOrEmpty(repo.OwnerLogin),
+		RepoPushedAt:   rfc3339(repo.RepoPushedAt),
+		RepoUpdatedAt:  rfc3339(repo.RepoUpdatedAt),
+		Stargazers:     repo.Stargazers,
+		Stale:          repo.Stale,
+	}
+
+	if config.ExportExtraFields {
+		repoV2.Forks = repo.Forks
+		repoV2.Watchers = repo.Watchers
+		repoV2.Size = repo.Size
+		repoV2.Fork = repo.Fork
+		repoV2.IsTemplate = repo.IsTemplate
+		repoV2.DefaultBranch = nullIfEmpty(repo.DefaultBranch)
+		repoV2.HasPages = repo.HasPages
+		repoV2.Visibility = nullIfEmpty(repo.Visibility)
+		repoV2.LatestRelease = nullIfEmpty(repo.LatestRelease)
+		repoV2.LatestReleasePublishedAt = rfc3339(repo.LatestReleasePublishedAt)
+		repoV2.Contributors = repo.Contributors
+		if repo.Languages != nil && *repo.Languages != "{}" {
+			repoV2.Languages = json.RawMessage(*repo.Languages)
+		}
+	}
+
+	return repoV2
+}
+
+func exportV2(repos []ExportedRepo) []ExportedRepoV2 {
+	reposV2 := make([]ExportedRepoV2, 0, len(repos))
+	for _, repo := range repos {
+		reposV2 = append(reposV2, repo.v2())
+	}
+	return reposV2
+}
@@ -0,0 +1,123 @@
+package apifier
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files in testdata/ with the current exports")
+
+// The fetcher's schema, with a few repositories covering what's published and what isn't
+var fixtureStatements = []string{
+	"create table `Repo` (`Id` INTEGER PRIMARY KEY NOT NULL, `Name` TEXT NULL, `FullName` TEXT NULL, `GithubLink` TEXT NULL, `Homepage` TEXT NULL, `Description` TEXT NULL, `Language` TEXT NULL, `Stargazers` INTEGER NULL, `Topics` TEXT NULL, `OpenIssues` INTEGER NULL, `Archived` INTEGER NULL, `CreatedAt` DATETIME NULL, `RepoPushedAt` DATETIME NULL, `RepoUpdatedAt` DATETIME NULL, `OwnerLogin` TEXT NULL, `OwnerAvatarUrl` TEXT NULL, `OwnerGravatarId` TEXT NULL, `OwnerType` TEXT NULL, `LicenseSpdxId` TEXT NULL, `LicenseName` TEXT NULL, `LastFetchedFromGithubAt` DATETIME NULL, `FirstFetchedFromGithubAt` DATETIME NULL, `GetRepoApiLastModifiedHeader` TEXT NULL, `VerifiedAt` DATETIME NULL, `VerificationOutcome` TEXT NULL, `RemovedAt` DATETIME NULL, `Forks` INTEGER NULL, `Watchers` INTEGER NULL, `Size` INTEGER NULL, `Fork` INTEGER NULL, `IsTemplate` INTEGER NULL, `DefaultBranch` TEXT NULL, `HasPages` INTEGER NULL, `Visibility` TEXT NULL, `NodeId` TEXT NULL, `LastSeenCycle` INTEGER NULL)",
+	"create table `State` (`Name` TEXT PRIMARY KEY NOT NULL, `Value` TEXT NULL)",
+	"create table `RepoRelease` (`RepoId` INTEGER PRIMARY KEY NOT NULL, `TagName` TEXT NULL, `Name` TEXT NULL, `PublishedAt` DATETIME NULL)",
+	"create table `RepoContributors` (`RepoId` INTEGER PRIMARY KEY NOT NULL, `Count` INTEGER NULL)",
+	"create table `RepoLanguage` (`RepoId` INTEGER NOT NULL, `Language` TEXT NOT NULL, `Bytes` INTEGER NULL, PRIMARY KEY (`RepoId`, `Language`))",
+	"create table `RepoNameHistory` (`Id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `RepoId` INTEGER NOT NULL, `OldFullName` TEXT NULL, `NewFullName` TEXT NULL, `ChangedAt` DATETIME NULL)",
+	"create index `RepoFullNameNocase` on `Repo` (`FullName` collate nocase)",
+
+	"insert into State (Name, Value) values ('minimum_stars', '5'), ('cycle', '20')",
+
+	// Enriched, with every extra field
+	"insert into Repo (Id, Name, FullName, GithubLink, Homepage, Description, Language, Stargazers, Archived, CreatedAt, RepoPushedAt, RepoUpdatedAt, OwnerLogin, OwnerAvatarUrl, LicenseSpdxId, LicenseName, LastFetchedFromGithubAt, Forks, Watchers, Size, Fork, IsTemplate, DefaultBranch, HasPages, Visibility, LastSeenCycle) " +
+		"values (1, 'alpha', 'octo/alpha', 'https://github.com/octo/alpha', 'https://alpha.example', 'Ships fast :rocket:', 'Go', 500, 0, '2015-03-04 05:06:07', '2025-12-30 10:00:00', '2025-12-31 11:00:00', 'octo', 'https://avatars.example/octo', 'MIT', 'MIT License', '2026-01-01 00:00:00', 40, 500, 1234, 0, 0, 'main', 1, 'public', 20)",
+	"insert into RepoRelease (RepoId, TagName, Name, PublishedAt) values (1, 'v1.2.0', 'The second one', '2025-11-10 12:13:14')",
+	"insert into RepoContributors (RepoId, Count) values (1, 17)",
+	"insert into RepoLanguage (RepoId, Language, Bytes) values (1, 'Go', 90000), (1, 'Shell', 1200)",
+	"insert into RepoNameHistory (RepoId, OldFullName, NewFullName, ChangedAt) values (1, 'octo/alpha-old', 'octo/alpha', '2025-06-01 00:00:00')",
+
+	// Not fetched since the extra fields were added, and not seen for a while - flagged as stale
+	"insert into Repo (Id, Name, FullName, GithubLink, Homepage, Description, Language, Stargazers, Archived, CreatedAt, RepoPushedAt, RepoUpdatedAt, OwnerLogin, OwnerAvatarUrl, LastSeenCycle) " +
+		"values (2, 'beta', 'octo/beta', 'https://github.com/octo/beta', '', '', 'Python', 200, 1, '2012-01-02 03:04:05', '2020-01-01 00:00:00', '2020-01-02 00:00:00', 'octo', 'https://avatars.example/octo', 1)",
+
+	// Below the fetcher's minimum stars
+	"insert into Repo (Id, Name, FullName, Language, Stargazers, LastSeenCycle) values (3, 'gamma', 'octo/gamma', 'Go', 3, 20)",
+
+	// Deleted last month - only in removed/1
+	"insert into Repo (Id, Name, FullName, GithubLink, Description, Language, Stargazers, VerificationOutcome, RemovedAt, LastSeenCycle) " +
+		"values (4, 'delta', 'octo/delta', 'https://github.com/octo/delta', 'Gone', 'Rust', 300, 'deleted', '2025-12-15 08:00:00', 18)",
+}
+
+func createExportFixture(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repos.db")
+	fixture, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.Close()
+
+	for _, statement := range fixtureStatements {
+		if _, err := fixture.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	return path
+}
+
+func readGzipFile(t *testing.T, path string) []byte {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExportMatchesGoldenFiles(t *testing.T) {
+	previousTimeNow := timeNow
+	timeNow = func() time.Time { return time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC) }
+	t.Cleanup(func() { timeNow = previousTimeNow })
+
+	cfg := DefaultConfig()
+	cfg.DatabasePath = createExportFixture(t)
+	cfg.OutputDir = t.TempDir()
+	cfg.StalePolicy = STALE_POLICY_FLAG
+	cfg.ExportExtraFields = true
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for exported, golden := range map[string]string{
+		"all/1":    "all-1.json",
+		"v2/all/1": "v2-all-1.json",
+		"metadata": "metadata.json",
+	} {
+		actual := readGzipFile(t, filepath.Join(cfg.OutputDir, exported))
+		goldenPath := filepath.Join("testdata", golden)
+
+		if *updateGolden {
+			if err := os.WriteFile(goldenPath, actual, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := os.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s differs from %s - if the change is intended, run go test -update\ngot:  %s\nwant: %s", exported, goldenPath, actual, expected)
+		}
+	}
+}
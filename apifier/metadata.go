@@ -1,4 +1,4 @@
-package main
+package apifier
 
 import (
 	"encoding/json"
@@ -18,11 +18,16 @@ type Language struct {
 }
 
 type Metadata struct {
+	SchemaVersion   int
+	MinimumStars    int64
 	CountOfAllRepos int64
 	AllReposPages   int64
 	CountOfAllStars int64
 	LastSyncTime    string
 	Languages       []Language
+
+	CountOfRemovedRepos int64
+	RemovedPages        int64
 }
 
 func numberOfPages(items int64) int64 {
@@ -34,14 +39,14 @@ func saveMetadata() {
 	var countOfAllRepos int64
 	err := db.QueryRow("SELECT COUNT(*) FROM ActiveRepo").Scan(&countOfAllRepos)
 	if err != nil {
-		log.Fatal(err)
+		log.Panic(err)
 	}
 
 	// Query for count of all stars
 	var countOfAllStars int64
 	err = db.QueryRow("SELECT SUM(Stargazers) FROM ActiveRepo").Scan(&countOfAllStars)
 	if err != nil {
-		log.Fatal(err)
+		log.Panic(err)
 	}
 
 	// Query for count of repos and stars per language
@@ -61,7 +66,7 @@ func saveMetadata() {
 		ORDER BY CountRepos DESC, Name
 	`)
 	if err != nil {
-		log.Fatal(err)
+		log.Panic(err)
 	}
 	defer closeOrPanic(rows)
 
@@ -73,7 +78,7 @@ func saveMetadata() {
 		var countOfRepos int64
 		err := rows.Scan(&languageName, &countOfStars, &countOfRepos)
 		if err != nil {
-			log.Fatal(err)
+			log.Panic(err)
 		}
 		languages = append(languages, Language{
 			Name:         languageName,
@@ -84,24 +89,39 @@ func saveMetadata() {
 		})
 	}
 	if err = rows.Err(); err != nil {
-		log.Fatal(err)
+		log.Panic(err)
 	}
 
+	countOfRemovedRepos := countRemovedRepos()
+
 	// Create the Metadata struct and populate it with the extracted data
+	now := timeNow()
 	data := Metadata{
+		SchemaVersion:   EXPORT_SCHEMA_VERSION,
+		MinimumStars:    minimumStargazers,
 		CountOfAllRepos: countOfAllRepos,
 		CountOfAllStars: countOfAllStars,
 		AllReposPages:   numberOfPages(countOfAllRepos),
-		LastSyncTime:    time.Now().Format(time.RFC1123),
+		LastSyncTime:    now.Format(time.RFC1123),
 		Languages:       languages,
+
+		CountOfRemovedRepos: countOfRemovedRepos,
+		RemovedPages:        max(numberOfPages(countOfRemovedRepos), 1),
 	}
+	saveMetadataFile(fmt.Sprintf("%s/metadata", config.OutputDir), data)
+
+	// The same, with the timestamp in the v2 format
+	data.SchemaVersion = EXPORT_SCHEMA_VERSION_V2
+	data.LastSyncTime = now.UTC().Format(time.RFC3339)
+	saveMetadataFile(fmt.Sprintf("%s/v2/metadata", config.OutputDir), data)
+}
 
+func saveMetadataFile(fileName string, data Metadata) {
 	// Marshal the data to JSON format
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		log.Fatal(err)
+		log.Panic(err)
 	}
 
-	fileSaveWaitGroup.Add(1)
-	go saveDataToGzipFile(fmt.Sprintf("%s/metadata", *outputDir), jsonData)
+	saveInBackground(func() { saveDataToGzipFile(fileName, jsonData) })
 }
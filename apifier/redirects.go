@@ -0,0 +1,64 @@
+package apifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Previous names of published repositories, with their current ones. Names taken over by another repository
+// aren't redirected anymore - looked up through the fetcher's RepoFullNameNocase index. Going from the oldest
+// rename to the newest makes the newest one win if a name was used by multiple repositories.
+const redirectsQuery = `
+	SELECT History.OldFullName, Repo.FullName
+	FROM RepoNameHistory History
+	JOIN Repo ON Repo.Id = History.RepoId
+	WHERE Repo.Id IN (SELECT Id FROM ActiveRepo)
+	  AND History.OldFullName != Repo.FullName COLLATE NOCASE
+	  AND NOT EXISTS (
+	      SELECT 1 FROM Repo Other
+	      WHERE Other.FullName = History.OldFullName COLLATE NOCASE AND Other.Id != Repo.Id
+	  )
+	ORDER BY History.ChangedAt, History.Id
+`
+
+// saveRedirects saves a map of previous "owner/name"s of renamed or transferred repositories to their current
+// ones, so links to the old names can be followed
+func saveRedirects() {
+	var tableExists bool
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'RepoNameHistory'").Scan(&tableExists)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	redirects := map[string]string{}
+
+	if tableExists {
+		rows, err := db.Query(redirectsQuery)
+		if err != nil {
+			log.Panicln("Could not query the repository name history:", err)
+		}
+		defer closeOrPanic(rows)
+
+		for rows.Next() {
+			var oldFullName, currentFullName string
+			if err := rows.Scan(&oldFullName, &currentFullName); err != nil {
+				log.Panicln(err)
+			}
+			redirects[oldFullName] = currentFullName
+		}
+		if err := rows.Err(); err != nil {
+			log.Panicln(err)
+		}
+	}
+
+	log.Printf("Saving %d redirects of renamed repositories\n", len(redirects))
+
+	jsonData, err := json.Marshal(redirects)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	saveInBackground(func() { saveDataToGzipFile(fmt.Sprintf("%s/redirects", config.OutputDir), jsonData) })
+	saveInBackground(func() { saveDataToGzipFile(fmt.Sprintf("%s/v2/redirects", config.OutputDir), jsonData) })
+}
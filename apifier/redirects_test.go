@@ -0,0 +1,67 @@
+package apifier
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedirectsQuery(t *testing.T) {
+	fixture, err := sql.Open("sqlite3", createExportFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.Close()
+
+	for _, statement := range []string{
+		"create view ActiveRepo as select Id from Repo where RemovedAt is null",
+		"insert into Repo (Id, Name, FullName, Stargazers, LastSeenCycle) values (5, 'Taken', 'Octo/Taken', 100, 20)",
+		// Taken over by repository 5, with different case
+		"insert into RepoNameHistory (RepoId, OldFullName, NewFullName, ChangedAt) values (1, 'octo/taken', 'octo/alpha', '2025-07-01 00:00:00')",
+		// Only the case changed
+		"insert into RepoNameHistory (RepoId, OldFullName, NewFullName, ChangedAt) values (1, 'OCTO/ALPHA', 'octo/alpha', '2025-08-01 00:00:00')",
+		// Renamed from the name of a deleted repository, which still holds it
+		"insert into RepoNameHistory (RepoId, OldFullName, NewFullName, ChangedAt) values (2, 'Octo/Delta', 'octo/beta', '2025-09-01 00:00:00')",
+	} {
+		if _, err := fixture.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	rows, err := fixture.Query(redirectsQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirects := map[string]string{}
+	for rows.Next() {
+		var oldFullName, currentFullName string
+		if err := rows.Scan(&oldFullName, &currentFullName); err != nil {
+			t.Fatal(err)
+		}
+		redirects[oldFullName] = currentFullName
+	}
+	rows.Close()
+	if expected := map[string]string{"octo/alpha-old": "octo/alpha"}; !reflect.DeepEqual(redirects, expected) {
+		t.Errorf("expected redirects %v, got %v", expected, redirects)
+	}
+
+	// Looking up who holds an old name mustn't scan every repository once per rename
+	plan, err := fixture.Query("EXPLAIN QUERY PLAN " + redirectsQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plan.Close()
+	details := []string{}
+	for plan.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := plan.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		details = append(details, detail)
+	}
+	if !strings.Contains(strings.Join(details, "\n"), "SEARCH Other USING COVERING INDEX RepoFullNameNocase") {
+		t.Errorf("expected names to be looked up through RepoFullNameNocase, the query plan is:\n%s", strings.Join(details, "\n"))
+	}
+}
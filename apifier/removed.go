@@ -0,0 +1,95 @@
+package apifier
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ExportedRemovedRepo is a popular repository that recently disappeared from GitHub, published in removed/N
+type ExportedRemovedRepo struct {
+	Id          int64   `json:"Id"`
+	FullName    string  `json:"FullName"`
+	GithubLink  string  `json:"GithubLink"`
+	Description *string `json:"Description"`
+	Language    *string `json:"Language"`
+	Stargazers  int64   `json:"Stargazers"`
+	// Why it's gone: "deleted", "dmca", "tos-disabled", or "replaced" - by a new repository with the same name
+	Reason    string  `json:"Reason"`
+	RemovedAt *string `json:"RemovedAt"`
+}
+
+// removedReposCondition selects repos which were removed within config.RemovedWindow, and were popular enough
+// to be published before that
+func removedReposCondition() string {
+	// xorm saves times as local time in this format, which compares correctly as a string
+	cutoff := timeNow().Add(-config.RemovedWindow).Format("2006-01-02 15:04:05")
+	return fmt.Sprintf("coalesce(VerificationOutcome, '') in %s and RemovedAt >= '%s' and Stargazers >= %d",
+		GONE_OUTCOMES_SQL_LIST, cutoff, minimumStargazers)
+}
+
+func countRemovedRepos() int64 {
+	var count int64
+	err := db.QueryRow("SELECT COUNT(*) FROM Repo WHERE " + removedReposCondition()).Scan(&count)
+	if err != nil {
+		log.Panicln("Could not count removed repositories:", err)
+	}
+	return count
+}
+
+func scanRemovedRepo(rows *sql.Rows) ExportedRemovedRepo {
+	var repo ExportedRemovedRepo
+	var fullName, githubLink *string
+	var removedAt *time.Time
+	err := rows.Scan(&repo.Id, &fullName, &githubLink, &repo.Description, &repo.Language, &repo.Stargazers, &repo.Reason, &removedAt)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	repo.FullName = valueOrEmpty(fullName)
+	repo.GithubLink = valueOrEmpty(githubLink)
+	repo.Description = nullIfEmpty(repo.Description)
+	repo.Language = nullIfEmpty(repo.Language)
+	repo.RemovedAt = rfc3339(removedAt)
+	return repo
+}
+
+// exportRemoved saves the recently removed repositories, most recently removed first, as removed/N - in the
+// same format in the original tree and in v2
+func exportRemoved() {
+	page := 1
+	for offset := 0; ; offset += JSON_PAGINATION_PAGE_SIZE {
+		rows, err := db.Query(`
+			SELECT Id, FullName, GithubLink, Description, Language, Stargazers, VerificationOutcome, RemovedAt
+			FROM Repo
+			WHERE `+removedReposCondition()+`
+			ORDER BY RemovedAt DESC, Stargazers DESC, Id
+			LIMIT $1 OFFSET $2
+		`, JSON_PAGINATION_PAGE_SIZE, offset)
+		if err != nil {
+			log.Panicln(err)
+		}
+
+		records := []ExportedRemovedRepo{}
+		for rows.Next() {
+			records = append(records, scanRemovedRepo(rows))
+		}
+		if err := rows.Err(); err != nil {
+			log.Panicln(err)
+		}
+		closeOrPanic(rows)
+
+		// Always save the first page, even if empty, so clients don't get a 404
+		if len(records) > 0 || page == 1 {
+			fileName := fmt.Sprintf("removed/%d", page)
+			saveInBackground(func() { saveToFile(fmt.Sprintf("%s/%s", config.OutputDir, fileName), records) })
+			saveInBackground(func() { saveToFile(fmt.Sprintf("%s/v2/%s", config.OutputDir, fileName), records) })
+		}
+
+		if len(records) < JSON_PAGINATION_PAGE_SIZE {
+			return
+		}
+		page++
+	}
+}
@@ -0,0 +1,91 @@
+package apifier
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	STALE_POLICY_EXCLUDE = "exclude"
+	STALE_POLICY_FLAG    = "flag"
+)
+
+// The fetcher counts its sweeps under this key in the State table, and stamps repos with the one which last found them
+const CYCLE_STATE_KEY = "cycle"
+
+// The fetcher's current cycle - repos last found by a cycle before it weren't seen for the difference
+var fetcherCycle int64
+
+// readFetcherCycle returns the fetcher's current cycle, or 0 if it hasn't counted any yet
+func readFetcherCycle() int64 {
+	var value string
+	err := db.QueryRow("SELECT Value FROM State WHERE Name = ?", CYCLE_STATE_KEY).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0
+	} else if err != nil {
+		log.Panicln("Could not read the fetcher's cycle:", err)
+	}
+
+	var cycle int64
+	if err := json.Unmarshal([]byte(value), &cycle); err != nil {
+		log.Panicln("Could not parse the fetcher's cycle:", err)
+	}
+	return cycle
+}
+
+// staleCondition is an sql expression true for repos the fetcher hasn't seen recently enough - they're most
+// likely deleted, or not popular enough anymore. It's put into a view, so can't use parameters.
+func staleCondition() string {
+	conditions := []string{}
+
+	if config.MaxNotSeenCycles >= 0 {
+		conditions = append(conditions, fmt.Sprintf("coalesce(LastSeenCycle, 0) < %d", fetcherCycle-config.MaxNotSeenCycles))
+	}
+
+	if config.MaxAge > 0 {
+		// xorm saves times as local time in this format, which compares correctly as a string
+		cutoff := timeNow().Add(-config.MaxAge).Format("2006-01-02 15:04:05")
+		conditions = append(conditions, fmt.Sprintf("coalesce(LastFetchedFromGithubAt, '') < '%s'", cutoff))
+	}
+
+	if len(conditions) == 0 {
+		return "0"
+	}
+	return "(" + strings.Join(conditions, " or ") + ")"
+}
+
+// The fetcher's verifier marks repositories GitHub doesn't serve anymore with these outcomes
+const GONE_OUTCOMES_SQL_LIST = "('deleted', 'dmca', 'tos-disabled', 'replaced')"
+
+// activeRepoFilter is the condition for repos to be published at all
+func activeRepoFilter() string {
+	filter := fmt.Sprintf("Repo.Stargazers >= %d and coalesce(Repo.VerificationOutcome, '') not in %s", minimumStargazers, GONE_OUTCOMES_SQL_LIST)
+	if config.StalePolicy == STALE_POLICY_EXCLUDE {
+		filter += " and not " + staleCondition()
+	}
+	if config.ExcludeForks {
+		filter += " and not coalesce(Repo.Fork, 0)"
+	}
+	if config.ExcludeTemplates {
+		filter += " and not coalesce(Repo.IsTemplate, 0)"
+	}
+	return filter
+}
+
+func countStaleRepos() {
+	var stale int64
+	err := db.QueryRow("SELECT COUNT(*) FROM Repo WHERE Stargazers >= ? AND "+staleCondition(), minimumStargazers).Scan(&stale)
+	if err != nil {
+		log.Panicln("Could not count stale repositories:", err)
+	}
+
+	if config.StalePolicy == STALE_POLICY_EXCLUDE {
+		log.Printf("Excluding %d stale repositories\n", stale)
+	} else {
+		log.Printf("Flagging %d stale repositories\n", stale)
+	}
+}
@@ -4,6 +4,7 @@ import "flag"
 
 var contentDirectory = flag.String("dir", ".", "from where to serve files")
 var listenAddress = flag.String("listen-on", "127.0.0.1:10002", "address to listen on")
+var graphqlFixtures = flag.String("graphql-fixtures", "", "json file of node IDs to repositories, served at /graphql")
 
 func init() {
 	flag.Parse()
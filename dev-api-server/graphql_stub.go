@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// graphqlStub stands in for GitHub's GraphQL API, answering the fetcher's nodes(ids: ...) queries, so refreshing
+// repositories can be tried out locally with -graphql-api-url=http://127.0.0.1:10002/graphql. Nodes are read
+// from -graphql-fixtures, a json object of node IDs to repositories - any other ID is reported as not found.
+func graphqlStub(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Query     string `json:"query"`
+		Variables struct {
+			Ids []string `json:"ids"`
+		} `json:"variables"`
+	}
+	if req.Method != "POST" || json.NewDecoder(req.Body).Decode(&body) != nil || body.Query == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(`{"message":"Problems parsing JSON"}`))
+		return
+	}
+
+	fixtures := map[string]json.RawMessage{}
+	if *graphqlFixtures != "" {
+		data, err := os.ReadFile(*graphqlFixtures)
+		if err == nil {
+			err = json.Unmarshal(data, &fixtures)
+		}
+		if err != nil {
+			log.Printf("[graphql] Could not read %s: %v\n", *graphqlFixtures, err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	type graphqlError struct {
+		Type    string `json:"type"`
+		Path    []any  `json:"path"`
+		Message string `json:"message"`
+	}
+
+	nodes := make([]json.RawMessage, len(body.Variables.Ids))
+	errors := []graphqlError{}
+	for i, id := range body.Variables.Ids {
+		if node, ok := fixtures[id]; ok {
+			nodes[i] = node
+		} else {
+			nodes[i] = json.RawMessage("null")
+			errors = append(errors, graphqlError{
+				Type:    "NOT_FOUND",
+				Path:    []any{"nodes", i},
+				Message: "Could not resolve to a node with the global id of '" + id + "'",
+			})
+		}
+	}
+	log.Printf("[graphql] %d nodes requested, %d not found\n", len(nodes), len(errors))
+
+	response := map[string]any{
+		"data": map[string]any{
+			"rateLimit": map[string]any{"cost": 1, "remaining": 4999, "resetAt": time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+			"nodes":     nodes,
+		},
+	}
+	if len(errors) > 0 {
+		response["errors"] = errors
+	}
+	json.NewEncoder(writer).Encode(response)
+}
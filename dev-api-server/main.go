@@ -35,7 +35,11 @@ func middleware(h http.Handler) http.Handler {
 }
 
 func main() {
-	handler := middleware(http.FileServer(http.Dir(*contentDirectory)))
+	handler := http.NewServeMux()
+	handler.Handle("/", middleware(http.FileServer(http.Dir(*contentDirectory))))
+	handler.Handle("/client/v4/", logRequest(http.HandlerFunc(purgeCacheStub)))
+	handler.Handle("/graphql", logRequest(http.HandlerFunc(graphqlStub)))
+
 	err := http.ListenAndServe(*listenAddress, handler)
 	if err != nil {
 		log.Fatalln(err)
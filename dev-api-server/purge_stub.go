@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// purgeCacheStub stands in for Cloudflare's purge_cache endpoint, so the orchestrator's cache purging can be
+// tried out locally with -cloudflare-api-url=http://127.0.0.1:10002/client/v4
+func purgeCacheStub(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	path := strings.TrimPrefix(req.URL.Path, "/client/v4/zones/")
+	zone, endpoint, _ := strings.Cut(path, "/")
+	if req.Method != "POST" || zone == "" || endpoint != "purge_cache" {
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write([]byte(`{"success":false,"errors":[{"code":7003,"message":"No route for the URI"}]}`))
+		return
+	}
+
+	var body struct {
+		Files []string `json:"files"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || len(body.Files) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte(`{"success":false,"errors":[{"code":1012,"message":"Request must contain one of \"purge_everything\", \"files\", \"tags\", \"hosts\" or \"prefixes\""}]}`))
+		return
+	}
+
+	for _, file := range body.Files {
+		log.Printf("[purge] zone %s: %s\n", zone, file)
+	}
+
+	writer.Write([]byte(`{"success":true,"errors":[],"messages":[],"result":{"id":"` + zone + `"}}`))
+}
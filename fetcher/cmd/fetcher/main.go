@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/karolba/top-of-github/fetcher"
+)
+
+func main() {
+	config := fetcher.DefaultConfig()
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if flag.Arg(0) == "status" {
+		printStatus(config)
+		return
+	}
+
+	err := fetcher.Run(context.Background(), config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// printStatus shows the shards of the current sweep and which workers crawl them
+func printStatus(config fetcher.Config) {
+	sweep, shards := fetcher.Status(config)
+	if len(shards) == 0 {
+		fmt.Println("The sweep isn't sharded")
+		return
+	}
+
+	fmt.Printf("Sweep %d\n", sweep)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tSTARS\tCURSOR\tSTATE\tWORKER\tLEASE EXPIRES")
+	for _, shard := range shards {
+		maxStars := fmt.Sprint(shard.MaxStars)
+		if shard.MaxStars == fetcher.MAX_STARS_DISCOVER {
+			maxStars = "top"
+		}
+		cursor := fmt.Sprint(shard.CursorMaxStars)
+		if shard.State == "done" || shard.CursorMaxStars == fetcher.MAX_STARS_DISCOVER {
+			cursor = "-"
+		}
+		lease := "-"
+		if shard.LeaseOwner != "" {
+			lease = shard.LeaseExpiresAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%d..%s\t%s\t%s\t%s\t%s\n", shard.Id, shard.MinStars, maxStars, cursor, shard.State, shard.LeaseOwner, lease)
+	}
+	w.Flush()
+}
@@ -0,0 +1,115 @@
+package fetcher
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config is everything a fetcher run can be configured with
+type Config struct {
+	EnableRequestLog   bool
+	EnableResponsesLog bool
+	EnableSqlLog       bool
+	DatabasePath       string
+	MinimumStars       int64
+
+	// Where repositories are refreshed in batches - can point to a local stub, like the dev-api-server's
+	GraphqlApiUrl string
+
+	// The most starred repositories overall and in the most popular languages are searched for every
+	// HotTierInterval, between steps of the full sweep, with up to HotTierBudgetShare of search requests
+	HotTierSize        int
+	HotTierLanguages   int
+	HotTierInterval    time.Duration
+	HotTierBudgetShare float64
+
+	// The star space of a sweep is split into Shards shards, leased to workers for LeaseDuration at a time -
+	// workers sharing the database crawl in parallel. A single shard keeps the one search cursor of old.
+	Shards        int
+	WorkerId      string
+	LeaseDuration time.Duration
+
+	GithubAppId                    string
+	GithubAppInstallationId        string
+	GithubAppPrivateKeyPemFilePath string
+}
+
+// The config of the currently running fetcher - there's only ever one fetcher running in a process
+var config = DefaultConfig()
+
+// DefaultConfig returns the default configuration, with GitHub App credentials taken from the environment
+func DefaultConfig() Config {
+	return Config{
+		DatabasePath:  "state/repos.db",
+		MinimumStars:  5,
+		GraphqlApiUrl: "https://api.github.com/graphql",
+
+		HotTierSize:        1000,
+		HotTierLanguages:   10,
+		HotTierInterval:    time.Hour,
+		HotTierBudgetShare: 0.2,
+
+		Shards:        1,
+		WorkerId:      defaultWorkerId(),
+		LeaseDuration: 15 * time.Minute,
+
+		GithubAppId:                    os.Getenv("GITHUB_APP_APP_ID"),
+		GithubAppInstallationId:        os.Getenv("GITHUB_APP_INSTALLATION_ID"),
+		GithubAppPrivateKeyPemFilePath: os.Getenv("GITHUB_APP_PRIVATE_KEY_PEM_FILE_PATH"),
+	}
+}
+
+// defaultWorkerId identifies this process among the workers sharing a database
+func defaultWorkerId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// RegisterFlags binds the config to command line flags
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&c.EnableRequestLog, "enable-request-log", c.EnableRequestLog, "Log HTTP requests in ./logs/requests.log")
+	flags.BoolVar(&c.EnableResponsesLog, "enable-responses-log", c.EnableResponsesLog, "Log HTTP responses in ./logs/responses.log")
+	flags.BoolVar(&c.EnableSqlLog, "enable-sql-log", c.EnableSqlLog, "Log SQL queries/statements in ./logs/sql.log")
+	flags.StringVar(&c.DatabasePath, "database", c.DatabasePath, "Path to the sqlite database to use")
+	flags.Int64Var(&c.MinimumStars, "minimum-stars", c.MinimumStars, "Metadata about repositories of this many stars and up will be downloaded")
+	flags.StringVar(&c.GraphqlApiUrl, "graphql-api-url", c.GraphqlApiUrl, "GitHub's GraphQL API endpoint, used for refreshing repositories in batches")
+	flags.IntVar(&c.HotTierSize, "hot-tier-size", c.HotTierSize, "How many of the most starred repositories - overall and per language - to search for more often than the full sweep. 0 to disable")
+	flags.IntVar(&c.HotTierLanguages, "hot-tier-languages", c.HotTierLanguages, "For how many of the most popular languages to search for their most starred repositories too")
+	flags.DurationVar(&c.HotTierInterval, "hot-tier-interval", c.HotTierInterval, "How often to search for the most starred repositories")
+	flags.Float64Var(&c.HotTierBudgetShare, "hot-tier-budget-share", c.HotTierBudgetShare, "The most of search requests which can be spent on the most starred repositories, between 0 and 1")
+	flags.IntVar(&c.Shards, "shards", c.Shards, "Into how many shards to split the stars of a sweep, so several workers with their own GitHub Apps can crawl them in parallel")
+	flags.StringVar(&c.WorkerId, "worker-id", c.WorkerId, "Identifies this worker in leases on shards - keeping it across restarts lets a worker resume its shard right away")
+	flags.DurationVar(&c.LeaseDuration, "lease-duration", c.LeaseDuration, "How long a shard stays leased to a worker which stopped renewing it, before other workers take it over")
+}
+
+func (c *Config) validate() error {
+	required := map[string]string{
+		"GITHUB_APP_APP_ID":                    c.GithubAppId,
+		"GITHUB_APP_INSTALLATION_ID":           c.GithubAppInstallationId,
+		"GITHUB_APP_PRIVATE_KEY_PEM_FILE_PATH": c.GithubAppPrivateKeyPemFilePath,
+	}
+	for name, value := range required {
+		if value == "" {
+			return fmt.Errorf("missing required environment variable %s", name)
+		}
+	}
+	if c.HotTierBudgetShare < 0 || c.HotTierBudgetShare > 1 {
+		return fmt.Errorf("-hot-tier-budget-share has to be between 0 and 1, got %v", c.HotTierBudgetShare)
+	}
+	if c.Shards < 1 {
+		return fmt.Errorf("-shards has to be at least 1, got %v", c.Shards)
+	}
+	if c.Shards > 1 && c.WorkerId == "" {
+		return fmt.Errorf("-worker-id is required with more than one shard")
+	}
+	// Leases expire with a precision of a second, and are renewed a few times during one
+	if c.Shards > 1 && c.LeaseDuration < time.Minute {
+		return fmt.Errorf("-lease-duration has to be at least a minute, got %v", c.LeaseDuration)
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package fetcher
 
 import (
 	"net/url"
@@ -13,7 +13,7 @@ import (
 
 func initialiseDb() *xorm.Engine {
 	engine := lo.Must(xorm.NewEngine("sqlite3", (&url.URL{
-		Path: *databasePath,
+		Path: config.DatabasePath,
 		RawQuery: url.Values{
 			"mode":                {"rwc"},
 			"_journal_mode":       {"WAL"},
@@ -28,23 +28,14 @@ func initialiseDb() *xorm.Engine {
 
 	engine.SetMapper(names.SameMapper{})
 
-	if *enableSqlLog {
+	if config.EnableSqlLog {
 		sqlLog := lo.Must(os.OpenFile("logs/sql.log", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666))
 		engine.SetLogger(log.NewSimpleLogger(sqlLog))
 		engine.Logger().SetLevel(log.LOG_DEBUG)
 		engine.ShowSQL(true)
 	}
 
-	// This creates a table if it doesn't exist, but doesn't update the schema it if differs from code
-	lo.Must0(engine.Sync(
-		new(Repo),
-		new(State),
-	))
-
-	lo.Must(engine.Exec(`
-		create unique index if not exists RepoNotSeenSinceCounter on Repo(NotSeenSinceCounter desc, Id asc);
-		create index if not exists RepoFullName on Repo(FullName);
-	`))
+	migrate(engine)
 
 	return engine
 }
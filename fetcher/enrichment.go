@@ -0,0 +1,397 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/samber/lo/parallel"
+	"xorm.io/xorm"
+)
+
+const (
+	// How many repositories to enrich at once - each one takes up to 3 requests
+	ENRICHMENT_PARALLELISM = 8
+	// How long a single round of enriching can take, so searching isn't held up for too long
+	ENRICHMENT_TIME_BUDGET = 2 * time.Minute
+	// How often to refresh details of a repository
+	ENRICHMENT_REFRESH_AFTER = 7 * 24 * time.Hour
+	// Requests needed for one repository at most
+	ENRICHMENT_REQUESTS_PER_REPO = 3
+	// How long to wait before trying a repository which failed to be enriched again - doubled with every
+	// failure in a row, up to ENRICHMENT_REFRESH_AFTER
+	ENRICHMENT_RETRY_AFTER = time.Hour
+)
+
+// RepoEnrichment tracks when details of a repository were last fetched, and the ETags to fetch them again
+// with conditional requests - which don't count towards the rate limit if nothing changed
+type RepoEnrichment struct {
+	RepoId            int64 `xorm:"pk notnull"`
+	EnrichedAt        time.Time
+	LanguagesETag     string
+	LatestReleaseETag string
+	ContributorsETag  string
+	// Failures in a row, and when the repository can be tried again after the last one
+	Failures   int64
+	RetryAfter time.Time
+}
+
+// RepoLanguage is how many bytes of code in a language a repository has
+type RepoLanguage struct {
+	RepoId   int64  `xorm:"pk notnull"`
+	Language string `xorm:"pk notnull"`
+	Bytes    int64
+}
+
+// RepoRelease is the latest release of a repository
+type RepoRelease struct {
+	RepoId      int64 `xorm:"pk notnull"`
+	TagName     string
+	Name        string
+	PublishedAt time.Time
+}
+
+// RepoContributors is how many contributors - including anonymous ones - a repository has
+type RepoContributors struct {
+	RepoId int64 `xorm:"pk notnull"`
+	// nil if GitHub won't list them
+	Count *int64
+}
+
+// conditionalResponse is a response to a GET with If-None-Match
+type conditionalResponse struct {
+	StatusCode int
+	Body       []byte
+	ETag       string
+	Link       string
+	Ratelimit  Ratelimit
+}
+
+func (r conditionalResponse) notModified() bool {
+	return r.StatusCode == http.StatusNotModified
+}
+
+func conditionalGet(githubClient *http.Client, path string, etag string) (result conditionalResponse, err error) {
+	reqUrl := lo.Must(url.Parse("https://api.github.com" + path))
+	req := lo.Must(http.NewRequest("GET", reqUrl.String(), nil))
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	if config.EnableRequestLog {
+		reqLogger.Println(string(lo.Must(httputil.DumpRequest(req, false))))
+	}
+
+	response, err := githubClient.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+
+	if config.EnableResponsesLog {
+		resLogger.Println(string(lo.Must(httputil.DumpResponse(response, false))))
+	}
+
+	result.StatusCode = response.StatusCode
+	result.ETag = response.Header.Get("ETag")
+	result.Link = response.Header.Get("Link")
+
+	result.Ratelimit = ratelimitFromResponse(response)
+
+	result.Body, err = io.ReadAll(response.Body)
+	return result, err
+}
+
+// enrichment is everything fetched about a single repository
+type enrichment struct {
+	Repo       Repo
+	Previous   RepoEnrichment
+	Ratelimits []Ratelimit
+	Failed     bool
+
+	Languages            conditionalResponse
+	LatestRelease        conditionalResponse
+	Contributors         conditionalResponse
+	LanguagesFetched     bool
+	LatestReleaseFetched bool
+	ContributorsFetched  bool
+}
+
+func enrichRepo(githubClient *http.Client, repo Repo, previous RepoEnrichment) (result enrichment) {
+	result.Repo = repo
+	result.Previous = previous
+
+	get := func(path string, etag string) (conditionalResponse, bool) {
+		response, err := conditionalGet(githubClient, path, etag)
+		if err != nil {
+			log.Printf("[enrichment] Could not fetch %s: %v\n", path, err)
+			return response, false
+		}
+		result.Ratelimits = append(result.Ratelimits, response.Ratelimit)
+		return response, true
+	}
+
+	var ok bool
+	if result.Languages, ok = get(fmt.Sprintf("/repos/%s/languages", repo.FullName), previous.LanguagesETag); ok {
+		result.LanguagesFetched = true
+	}
+	if result.LatestRelease, ok = get(fmt.Sprintf("/repos/%s/releases/latest", repo.FullName), previous.LatestReleaseETag); ok {
+		result.LatestReleaseFetched = true
+	}
+	// With per_page=1, the number of the last page is the number of contributors
+	if result.Contributors, ok = get(fmt.Sprintf("/repos/%s/contributors?per_page=1&anon=1", repo.FullName), previous.ContributorsETag); ok {
+		result.ContributorsFetched = true
+	}
+
+	result.Failed = !result.LanguagesFetched || !result.LatestReleaseFetched || !result.ContributorsFetched
+	return result
+}
+
+var lastPageRegexp = regexp.MustCompile(`[?&]page=(\d+)[^>]*>; rel="last"`)
+
+// countFromPagination returns the number of items in a list fetched with per_page=1
+func countFromPagination(response conditionalResponse) (int64, error) {
+	if match := lastPageRegexp.FindStringSubmatch(response.Link); match != nil {
+		return strconv.ParseInt(match[1], 10, 64)
+	}
+
+	// A single page - or an empty body, for repositories without any commits
+	if len(strings.TrimSpace(string(response.Body))) == 0 {
+		return 0, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(response.Body, &items); err != nil {
+		return 0, err
+	}
+	return int64(len(items)), nil
+}
+
+func saveLanguages(tx *xorm.Session, repoId int64, response conditionalResponse) error {
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("languages: unexpected status %d", response.StatusCode)
+	}
+
+	var languages map[string]int64
+	if err := json.Unmarshal(response.Body, &languages); err != nil {
+		return err
+	}
+
+	if _, err := tx.Where("RepoId = ?", repoId).Delete(&RepoLanguage{}); err != nil {
+		return err
+	}
+	for language, bytes := range languages {
+		if _, err := tx.Insert(&RepoLanguage{RepoId: repoId, Language: language, Bytes: bytes}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveLatestRelease(tx *xorm.Session, repoId int64, response conditionalResponse) error {
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("latest release: unexpected status %d", response.StatusCode)
+	}
+
+	if _, err := tx.Where("RepoId = ?", repoId).Delete(&RepoRelease{}); err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		// No releases
+		return nil
+	}
+
+	var release struct {
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		PublishedAt time.Time `json:"published_at"`
+	}
+	if err := json.Unmarshal(response.Body, &release); err != nil {
+		return err
+	}
+
+	_, err := tx.Insert(&RepoRelease{RepoId: repoId, TagName: release.TagName, Name: release.Name, PublishedAt: release.PublishedAt})
+	return err
+}
+
+// contributorsUnlisted tells whether GitHub refused to list contributors for good - "The history or contributor
+// list is too large to list contributors for this repository via the API" for the biggest repositories. A 403
+// is also what exceeding the rate limit looks like.
+func contributorsUnlisted(response conditionalResponse) bool {
+	switch response.StatusCode {
+	case http.StatusUnprocessableEntity:
+		return true
+	case http.StatusForbidden:
+		var decoded githubErrorResponse
+		_ = json.Unmarshal(response.Body, &decoded)
+		return strings.Contains(decoded.Message, "too large")
+	}
+	return false
+}
+
+func saveContributors(tx *xorm.Session, repoId int64, response conditionalResponse) error {
+	var count *int64
+	switch {
+	// 204 No Content: an empty repository
+	case response.StatusCode == http.StatusOK || response.StatusCode == http.StatusNoContent:
+		counted, err := countFromPagination(response)
+		if err != nil {
+			return err
+		}
+		count = &counted
+	case contributorsUnlisted(response):
+		// Saved without a count, so the repository isn't tried again until it's due for a refresh
+	default:
+		return fmt.Errorf("contributors: unexpected status %d", response.StatusCode)
+	}
+
+	if _, err := tx.Where("RepoId = ?", repoId).Delete(&RepoContributors{}); err != nil {
+		return err
+	}
+	_, err := tx.Insert(&RepoContributors{RepoId: repoId, Count: count})
+	return err
+}
+
+// retryAfter returns how long to wait before enriching a repository again after failures in a row
+func retryAfter(failures int64) time.Duration {
+	backoff := ENRICHMENT_RETRY_AFTER
+	for i := int64(1); i < failures && backoff < ENRICHMENT_REFRESH_AFTER; i++ {
+		backoff *= 2
+	}
+	return min(backoff, ENRICHMENT_REFRESH_AFTER)
+}
+
+// saveEnrichment saves everything that changed and returns whether all of it did. Parts that failed are left as
+// they were, and the repository is tried again once retryAfter passes.
+func saveEnrichment(db *xorm.Engine, result enrichment) (saved bool) {
+	lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
+		state := result.Previous
+		state.RepoId = result.Repo.Id
+
+		if result.LanguagesFetched && !result.Languages.notModified() {
+			if err := saveLanguages(tx, result.Repo.Id, result.Languages); err != nil {
+				log.Printf("[enrichment] %s: %v\n", result.Repo.FullName, err)
+				result.Failed = true
+			} else {
+				state.LanguagesETag = result.Languages.ETag
+			}
+		}
+
+		if result.LatestReleaseFetched && !result.LatestRelease.notModified() {
+			if err := saveLatestRelease(tx, result.Repo.Id, result.LatestRelease); err != nil {
+				log.Printf("[enrichment] %s: %v\n", result.Repo.FullName, err)
+				result.Failed = true
+			} else {
+				state.LatestReleaseETag = result.LatestRelease.ETag
+			}
+		}
+
+		if result.ContributorsFetched && !result.Contributors.notModified() {
+			if err := saveContributors(tx, result.Repo.Id, result.Contributors); err != nil {
+				log.Printf("[enrichment] %s: %v\n", result.Repo.FullName, err)
+				result.Failed = true
+			} else {
+				state.ContributorsETag = result.Contributors.ETag
+			}
+		}
+
+		if !result.Failed {
+			state.EnrichedAt = time.Now()
+			state.Failures = 0
+			state.RetryAfter = time.Time{}
+		} else {
+			state.Failures++
+			state.RetryAfter = time.Now().Add(retryAfter(state.Failures))
+		}
+
+		if lo.Must(tx.Exist(&RepoEnrichment{RepoId: state.RepoId})) {
+			lo.Must(tx.ID(state.RepoId).AllCols().Update(&state))
+		} else {
+			lo.Must(tx.Insert(&state))
+		}
+		return nil, nil
+	}))
+
+	return !result.Failed
+}
+
+// getReposToEnrich returns the most starred repositories whose details weren't fetched recently, together with
+// what was fetched about them before. Repositories which failed are skipped until they can be retried.
+func getReposToEnrich(db *xorm.Engine, howMany int) ([]Repo, []RepoEnrichment) {
+	type row struct {
+		Repo           `xorm:"extends"`
+		RepoEnrichment `xorm:"extends"`
+	}
+
+	now := time.Now()
+	refreshBefore := now.Add(-ENRICHMENT_REFRESH_AFTER).Format("2006-01-02 15:04:05")
+	var rows []row
+	lo.Must0(notGone(inCurrentShard(db.Table("Repo").
+		Join("LEFT", "RepoEnrichment", "RepoEnrichment.RepoId = Repo.Id").
+		Where("Repo.Stargazers >= ?", config.MinimumStars), "Repo.Stargazers"), "Repo").
+		And("(RepoEnrichment.EnrichedAt IS NULL OR RepoEnrichment.EnrichedAt < ?)", refreshBefore).
+		And("(RepoEnrichment.RetryAfter IS NULL OR RepoEnrichment.RetryAfter <= ?)", now.Format("2006-01-02 15:04:05")).
+		Desc("Repo.Stargazers").
+		Asc("Repo.Id").
+		Limit(howMany).
+		Find(&rows))
+
+	repos := make([]Repo, len(rows))
+	previous := make([]RepoEnrichment, len(rows))
+	for i, row := range rows {
+		repos[i] = row.Repo
+		previous[i] = row.RepoEnrichment
+	}
+	return repos, previous
+}
+
+// enrichRepos fetches details search doesn't return - language breakdowns, latest releases, contributor
+// counts - for the most starred repositories first, with the core API quota the verifier left over
+func enrichRepos(ctx context.Context, githubApiClient *http.Client, db *xorm.Engine) {
+	startedAt := time.Now()
+	enriched, failed := 0, 0
+
+	for ctx.Err() == nil && time.Since(startedAt) < ENRICHMENT_TIME_BUDGET {
+		available := availableRatelimit(db) / ENRICHMENT_REQUESTS_PER_REPO
+		if available <= 0 {
+			break
+		}
+
+		repos, previous := getReposToEnrich(db, min(ENRICHMENT_PARALLELISM, available))
+		if len(repos) == 0 {
+			break
+		}
+
+		results := parallel.Map(repos, func(repo Repo, index int) enrichment {
+			return enrichRepo(githubApiClient, repo, previous[index])
+		})
+
+		ratelimits := []Ratelimit{}
+		for _, result := range results {
+			if !saveEnrichment(db, result) {
+				failed++
+			} else {
+				enriched++
+			}
+
+			ratelimits = append(ratelimits, result.Ratelimits...)
+		}
+
+		if ratelimit := latestRatelimit(ratelimits); ratelimit.Known {
+			SetRepoRatelimit(db, ratelimit.Reset, ratelimit.Remaining)
+		}
+	}
+
+	if enriched > 0 || failed > 0 {
+		log.Printf("[enrichment] Enriched %d repositories in %v, %d failed\n", enriched, time.Since(startedAt).Round(time.Second), failed)
+	}
+}
@@ -0,0 +1,121 @@
+package fetcher
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// enrichedResult is an enrichment of the repository where every request got the given response
+func enrichedResult(repo Repo, previous RepoEnrichment, contributors conditionalResponse) enrichment {
+	return enrichment{
+		Repo:                 repo,
+		Previous:             previous,
+		Languages:            conditionalResponse{StatusCode: http.StatusOK, Body: []byte(`{"Go": 1000}`)},
+		LatestRelease:        conditionalResponse{StatusCode: http.StatusNotFound},
+		Contributors:         contributors,
+		LanguagesFetched:     true,
+		LatestReleaseFetched: true,
+		ContributorsFetched:  true,
+	}
+}
+
+func TestFailedEnrichmentsBackOff(t *testing.T) {
+	useTestDb(t)
+	db := initialiseDb()
+	defer db.Close()
+
+	repos := []Repo{{Id: 1, FullName: "octo/flaky", Stargazers: 1000}, {Id: 2, FullName: "octo/fine", Stargazers: 10}}
+	if _, err := db.Insert(&repos); err != nil {
+		t.Fatal(err)
+	}
+
+	rateLimited := conditionalResponse{StatusCode: http.StatusForbidden, Body: []byte(`{"message": "API rate limit exceeded for installation ID 1."}`)}
+	if saveEnrichment(db, enrichedResult(repos[0], RepoEnrichment{}, rateLimited)) {
+		t.Fatalf("expected a rate limited request for contributors to fail the enrichment")
+	}
+
+	toEnrich, previous := getReposToEnrich(db, ENRICHMENT_PARALLELISM)
+	if len(toEnrich) != 1 || toEnrich[0].Id != 2 {
+		t.Fatalf("expected only the repository which didn't fail to be enriched, got %+v", toEnrich)
+	}
+	if previous[0].Failures != 0 {
+		t.Errorf("expected no failures of the other repository, got %d", previous[0].Failures)
+	}
+
+	var state RepoEnrichment
+	if _, err := db.ID(1).Get(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Failures != 1 || !state.EnrichedAt.IsZero() || time.Until(state.RetryAfter) < ENRICHMENT_RETRY_AFTER-time.Minute {
+		t.Errorf("expected the failed repository to be retried in %v, got %+v", ENRICHMENT_RETRY_AFTER, state)
+	}
+
+	// Once the backoff passes, the repository is tried again - and backs off for longer if it fails again
+	if _, err := db.Exec("update RepoEnrichment set RetryAfter = ? where RepoId = 1", time.Now().Add(-time.Minute).Format("2006-01-02 15:04:05")); err != nil {
+		t.Fatal(err)
+	}
+	toEnrich, previous = getReposToEnrich(db, ENRICHMENT_PARALLELISM)
+	if len(toEnrich) != 2 || toEnrich[0].Id != 1 {
+		t.Fatalf("expected the failed repository to be retried first, got %+v", toEnrich)
+	}
+	saveEnrichment(db, enrichedResult(toEnrich[0], previous[0], rateLimited))
+	state = RepoEnrichment{}
+	if _, err := db.ID(1).Get(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Failures != 2 || time.Until(state.RetryAfter) < 2*ENRICHMENT_RETRY_AFTER-time.Minute {
+		t.Errorf("expected the second failure to back off for %v, got %+v", 2*ENRICHMENT_RETRY_AFTER, state)
+	}
+}
+
+func TestUnlistedContributorsAreFinal(t *testing.T) {
+	useTestDb(t)
+	db := initialiseDb()
+	defer db.Close()
+
+	repo := Repo{Id: 1, FullName: "torvalds/linux", Stargazers: 100000}
+	if _, err := db.Insert(&repo); err != nil {
+		t.Fatal(err)
+	}
+
+	tooLarge := conditionalResponse{
+		StatusCode: http.StatusForbidden,
+		Body:       []byte(`{"message": "The history or contributor list is too large to list contributors for this repository via the API.", "status": "403"}`),
+	}
+	if !saveEnrichment(db, enrichedResult(repo, RepoEnrichment{}, tooLarge)) {
+		t.Fatalf("expected contributors GitHub won't list to be saved as a result")
+	}
+
+	var contributors RepoContributors
+	if found, err := db.ID(1).Get(&contributors); err != nil || !found {
+		t.Fatalf("expected contributors to be saved, got %v (%v)", found, err)
+	}
+	if contributors.Count != nil {
+		t.Errorf("expected no count of contributors, got %d", *contributors.Count)
+	}
+
+	var state RepoEnrichment
+	if _, err := db.ID(1).Get(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.EnrichedAt.IsZero() || state.Failures != 0 {
+		t.Errorf("expected the repository to be enriched, got %+v", state)
+	}
+	if toEnrich, _ := getReposToEnrich(db, ENRICHMENT_PARALLELISM); len(toEnrich) != 0 {
+		t.Errorf("expected the repository not to be enriched again, got %+v", toEnrich)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for failures, expected := range map[int64]time.Duration{
+		1:  ENRICHMENT_RETRY_AFTER,
+		2:  2 * ENRICHMENT_RETRY_AFTER,
+		4:  8 * ENRICHMENT_RETRY_AFTER,
+		10: ENRICHMENT_REFRESH_AFTER,
+	} {
+		if backoff := retryAfter(failures); backoff != expected {
+			t.Errorf("expected to retry after %v following %d failures, got %v", expected, failures, backoff)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package fetcher
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+// startNextCycle ends the sweep's cycle - repositories it didn't find are now one more cycle behind
+func startNextCycle(db *xorm.Engine) {
+	SetCycle(db, GetCycle(db)+1)
+}
+
+func endWork(ctx context.Context, db *xorm.Engine) {
+	SetMaxStars(db, MAX_STARS_DISCOVER)
+	SetSearchWindow(db, SEARCH_WINDOW_DEFAULT)
+	SetMinimumStars(db, config.MinimumStars)
+	reportPredictions(db)
+	startNextCycle(db)
+}
+
+// crawl searches until the search cursor goes below the sweep's floor, or ctx is done - returning why it was.
+// Returns errLeaseLost if the lease on the shard being crawled was lost.
+func crawl(ctx context.Context, githubApiClient *http.Client, db *xorm.Engine) error {
+	for {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		maxStars := GetMaxStars(db)
+		if maxStars < sweepFloor() {
+			// We've downloaded everything there is
+			log.Printf("MaxStars decreased to %v - ending all work.", maxStars)
+			return nil
+		}
+
+		leaseLost := false
+		lo.TryCatchWithErrorValue(func() error {
+			if hotTierDue(db) {
+				refreshHotTier(ctx, githubApiClient, db)
+			} else {
+				doFetcherTask(ctx, githubApiClient, db)
+			}
+			refreshRepos(ctx, githubApiClient, db)
+			verifyRepos(ctx, githubApiClient, db)
+			enrichRepos(ctx, githubApiClient, db)
+			return nil
+		}, func(caught any) {
+			type stackTracer interface{ StackTrace() errors.StackTrace }
+
+			err, isAnActualError := caught.(error)
+			if isAnActualError && errors.Is(err, errLeaseLost) {
+				leaseLost = true
+				return
+			}
+			stError, isStackTraceError := err.(stackTracer)
+
+			if isAnActualError && isStackTraceError {
+				log.Printf("Caught an error in fetcherTask: %+v\n%+v\n", caught, stError.StackTrace())
+			} else {
+				log.Printf("Caught an error in fetcherTask: %+v\n", caught)
+			}
+			log.Println("Will sleep for 15s and try again")
+			sleepContext(ctx, time.Second*15)
+		})
+		if leaseLost {
+			return errLeaseLost
+		}
+	}
+}
+
+func fetcherTask(ctx context.Context, db *xorm.Engine) error {
+	githubApiClient := newGithubApiClient(context.Background())
+	if config.Shards > 1 {
+		return shardedFetcherTask(ctx, githubApiClient, db)
+	}
+
+	err := crawl(ctx, githubApiClient, db)
+	if err != nil {
+		return err
+	}
+	endWork(ctx, db)
+	return nil
+}
+
+// resetRunState forgets what an earlier Run in the same process left behind in package-level state
+func resetRunState() {
+	searchRequestsMade.Store(0)
+	hotTierRequestsMade.Store(0)
+	hotTierQueue = nil
+	predictionOverflowedAt = -1
+	currentShard = nil
+}
+
+// Run fetches repositories from GitHub into the database, returning once a whole sweep - from the most
+// starred repositories down to the minimum number of stars - is done. With config.Shards above 1, several
+// workers - each with their own credentials - can Run on the same database at once, each crawling the shards
+// of the sweep it claims, returning once there are none left to claim.
+func Run(ctx context.Context, cfg Config) error {
+	config = cfg
+	if err := config.validate(); err != nil {
+		return err
+	}
+	resetRunState()
+
+	if config.EnableRequestLog || config.EnableResponsesLog || config.EnableSqlLog {
+		lo.Must0(os.MkdirAll("logs", os.ModePerm), "Couldn't mkdir -p ./logs/")
+	}
+	lo.Must0(os.MkdirAll(filepath.Dir(config.DatabasePath), os.ModePerm), "Couldn't mkdir -p the database directory")
+
+	initialiseGithubAppLogs()
+	dbEngine := initialiseDb()
+	defer dbEngine.Close()
+	defer closeUpsertStatements(dbEngine)
+
+	return fetcherTask(ctx, dbEngine)
+}
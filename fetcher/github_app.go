@@ -1,57 +1,44 @@
-package main
+package fetcher
 
 import (
 	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/beatlabs/github-auth/app"
 	"github.com/beatlabs/github-auth/key"
 	"github.com/samber/lo"
 )
 
-func haveToGetEnvironmentVariable(name string) string {
-	val, ok := os.LookupEnv(name)
-	if !ok {
-		log.Panicf("Missing required environment variable %s\n", name)
-	}
-	if val == "" {
-		log.Panicf("Required environment variable %s is empty\n", name)
-	}
-	return val
-}
-
-var GITHUB_APP_APP_ID = haveToGetEnvironmentVariable("GITHUB_APP_APP_ID")
-var GITHUB_APP_INSTALLATION_ID = haveToGetEnvironmentVariable("GITHUB_APP_INSTALLATION_ID")
-var GITHUB_APP_PRIVATE_KEY_PEM_FILE_PATH = haveToGetEnvironmentVariable("GITHUB_APP_PRIVATE_KEY_PEM_FILE_PATH")
-var githubApiPrivateKeyPem []byte
-
-func init() {
-	githubApiPrivateKeyPem = lo.Must(os.ReadFile(GITHUB_APP_PRIVATE_KEY_PEM_FILE_PATH))
-}
-
 const MAX_RESULTS_PER_PAGE = 100
 const MAX_PAGES = 10
 
+// How long a request to GitHub can take at most - a stalled one mustn't hold up a worker past its lease
+const GITHUB_REQUEST_TIMEOUT = time.Minute
+
 var reqLogger *log.Logger
 var resLogger *log.Logger
 
 func initialiseGithubAppLogs() {
-	if *enableRequestLog {
+	if config.EnableRequestLog {
 		reqLogFile := lo.Must(os.OpenFile("logs/requests.log", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666))
 		reqLogger = log.New(reqLogFile, "\n[http-request] ", log.Flags())
 	}
 
-	if *enableResponsesLog {
+	if config.EnableResponsesLog {
 		resLogFile := lo.Must(os.OpenFile("logs/responses.log", os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666))
 		resLogger = log.New(resLogFile, "\n[http-response] ", log.Flags())
 	}
 }
 
 func newGithubApiClient(ctx context.Context) *http.Client {
+	githubApiPrivateKeyPem := lo.Must(os.ReadFile(config.GithubAppPrivateKeyPemFilePath))
 	ghApiPrivateKey := lo.Must(key.Parse(githubApiPrivateKeyPem))
-	appConfig := lo.Must(app.NewConfig(GITHUB_APP_APP_ID, ghApiPrivateKey))
-	installationConfig := lo.Must(appConfig.InstallationConfig(GITHUB_APP_INSTALLATION_ID))
-	return installationConfig.Client(ctx)
+	appConfig := lo.Must(app.NewConfig(config.GithubAppId, ghApiPrivateKey))
+	installationConfig := lo.Must(appConfig.InstallationConfig(config.GithubAppInstallationId))
+	client := installationConfig.Client(ctx)
+	client.Timeout = GITHUB_REQUEST_TIMEOUT
+	return client
 }
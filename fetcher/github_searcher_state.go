@@ -1,9 +1,10 @@
-package main
+package fetcher
 
 import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"github.com/samber/lo"
@@ -11,15 +12,30 @@ import (
 )
 
 const (
-	MAX_STARS_KEY               = "max_stars"
-	MAX_STARS_DEFAULT           = 200000
-	SEARCH_WINDOW_KEY           = "search_window"
-	SEARCH_WINDOW_DEFAULT       = 10000
-	DATE_START_SECOND_KEY       = "second_start"
-	DATE_SECONDS_WINDOW_KEY     = "seconds_window"
+	MAX_STARS_KEY = "max_stars"
+	// Sweeps start by finding out how many stars the most starred repository has
+	MAX_STARS_DISCOVER = math.MaxInt64
+	// A sweep starts above the most starred repository by 1/MAX_STARS_HEADROOM_FRACTION of its stars, plus one,
+	// for stars gained in the meantime
+	MAX_STARS_HEADROOM_FRACTION = 100
+
+	SEARCH_WINDOW_KEY       = "search_window"
+	SEARCH_WINDOW_DEFAULT   = 10000
+	DATE_START_SECOND_KEY   = "second_start"
+	DATE_SECONDS_WINDOW_KEY = "seconds_window"
+	// The pushed date range splits slices with too many repositories created in the same second
+	PUSHED_START_SECOND_KEY     = "pushed_second_start"
+	PUSHED_SECONDS_WINDOW_KEY   = "pushed_seconds_window"
 	GETREPO_RATELIMIT_RESET     = "getrepo_ratelimit_reset"
 	GETREPO_RATELIMIT_REMAINING = "getrepo_ratelimit_remaining"
 	DEFAULT_GETREPO_LIMIT       = 6000
+	GRAPHQL_RATELIMIT_RESET     = "graphql_ratelimit_reset"
+	GRAPHQL_RATELIMIT_REMAINING = "graphql_ratelimit_remaining"
+	DEFAULT_GRAPHQL_LIMIT       = 5000
+	// Read by the apifier as well - it publishes only repos the fetcher keeps up to date
+	MINIMUM_STARS_KEY = "minimum_stars"
+	// Counts sweeps - repositories are stamped with the cycle which last found them. Read by the apifier as well.
+	CYCLE_KEY = "cycle"
 )
 
 func getFromState[T any](db xorm.Interface, key string, defaultValue T) T {
@@ -37,33 +53,76 @@ func setToState[T any](db xorm.Interface, key string, value T) {
 	lo.Must(db.Exec("INSERT OR REPLACE INTO State(Name, Value) VALUES(?, ?)", key, string(lo.Must(json.Marshal(value)))))
 }
 
+// setCursor saves a key of the search cursor. In a sharded sweep, only while this worker holds the lease on the
+// shard - otherwise it panics with errLeaseLost, so that a worker which fell behind renewing its lease can't move
+// the cursor of the one which took the shard over.
+func setCursor[T any](db xorm.Interface, key string, value T) {
+	if currentShard == nil {
+		setToState(db, key, value)
+		return
+	}
+
+	condition, args := leaseHeld(currentShard.Id)
+	result := lo.Must(db.Exec(append([]any{
+		"INSERT OR REPLACE INTO State(Name, Value) SELECT ?, ? WHERE EXISTS (SELECT 1 FROM Shard WHERE " + condition + ")",
+		cursorKey(key), string(lo.Must(json.Marshal(value))),
+	}, args...)...))
+	if lo.Must(result.RowsAffected()) == 0 {
+		panic(errLeaseLost)
+	}
+}
+
 func GetMaxStars(db xorm.Interface) int64 {
-	return getFromState[int64](db, MAX_STARS_KEY, MAX_STARS_DEFAULT)
+	return getFromState[int64](db, cursorKey(MAX_STARS_KEY), MAX_STARS_DISCOVER)
 }
 func SetMaxStars(db xorm.Interface, stars int64) {
-	// TODO: this check could probably be done in a transaction, however only one process can currently be a fetcher, so whatever
+	// Every worker has its own cursor - in the shard it holds the lease on
 	if stars != GetMaxStars(db) {
 		log.Println("Changed max stars - resetting SearchDaysWindow and SearchStartDay")
 		DefaultRepoCreationDateRange().Save(db)
+		ResetRepoPushedDateRange(db)
 	}
-	setToState[int64](db, MAX_STARS_KEY, stars)
+	setCursor[int64](db, MAX_STARS_KEY, stars)
 }
 
 func GetSearchWindow(db xorm.Interface) int64 {
-	return getFromState[int64](db, SEARCH_WINDOW_KEY, SEARCH_WINDOW_DEFAULT)
+	return getFromState[int64](db, cursorKey(SEARCH_WINDOW_KEY), SEARCH_WINDOW_DEFAULT)
 }
 func SetSearchWindow(db xorm.Interface, win int64) {
-	setToState[int64](db, SEARCH_WINDOW_KEY, win)
+	setCursor[int64](db, SEARCH_WINDOW_KEY, win)
+}
+
+// SetMinimumStars records the star threshold of the last complete sweep
+func SetMinimumStars(db xorm.Interface, stars int64) {
+	setToState[int64](db, MINIMUM_STARS_KEY, stars)
+}
+
+func GetCycle(db xorm.Interface) int64 {
+	return getFromState[int64](db, CYCLE_KEY, 0)
+}
+func SetCycle(db xorm.Interface, cycle int64) {
+	setToState[int64](db, CYCLE_KEY, cycle)
 }
 
 func SetRepoRatelimit(db xorm.Interface, ratelimitReset time.Time, ratelimitRemaining int) {
-	setToState[int64](db, GETREPO_RATELIMIT_RESET, ratelimitReset.Unix())
-	setToState[int](db, GETREPO_RATELIMIT_REMAINING, ratelimitRemaining)
+	setToState[int64](db, workerKey(GETREPO_RATELIMIT_RESET), ratelimitReset.Unix())
+	setToState[int](db, workerKey(GETREPO_RATELIMIT_REMAINING), ratelimitRemaining)
 }
 func GetRepoRatelimit(db xorm.Interface) (ratelimitReset time.Time, ratelimitRemaining int) {
 	defaultReset := time.Now().Add(1 * time.Hour).Unix()
-	ratelimitReset = time.Unix(getFromState[int64](db, GETREPO_RATELIMIT_RESET, defaultReset), 0)
-	ratelimitRemaining = getFromState[int](db, GETREPO_RATELIMIT_REMAINING, DEFAULT_GETREPO_LIMIT)
+	ratelimitReset = time.Unix(getFromState[int64](db, workerKey(GETREPO_RATELIMIT_RESET), defaultReset), 0)
+	ratelimitRemaining = getFromState[int](db, workerKey(GETREPO_RATELIMIT_REMAINING), DEFAULT_GETREPO_LIMIT)
+	return
+}
+
+func SetGraphqlRatelimit(db xorm.Interface, ratelimitReset time.Time, ratelimitRemaining int) {
+	setToState[int64](db, workerKey(GRAPHQL_RATELIMIT_RESET), ratelimitReset.Unix())
+	setToState[int](db, workerKey(GRAPHQL_RATELIMIT_REMAINING), ratelimitRemaining)
+}
+func GetGraphqlRatelimit(db xorm.Interface) (ratelimitReset time.Time, ratelimitRemaining int) {
+	defaultReset := time.Now().Add(1 * time.Hour).Unix()
+	ratelimitReset = time.Unix(getFromState[int64](db, workerKey(GRAPHQL_RATELIMIT_RESET), defaultReset), 0)
+	ratelimitRemaining = getFromState[int](db, workerKey(GRAPHQL_RATELIMIT_REMAINING), DEFAULT_GRAPHQL_LIMIT)
 	return
 }
 
@@ -90,27 +149,33 @@ func (r RepoCreationDateRange) CoversEverything() bool {
 }
 
 func GetRepoCreationDateRange(db *xorm.Engine) (r RepoCreationDateRange) {
-	r.startingSecond = getFromState[int64](db, DATE_START_SECOND_KEY, -1)
+	r.startingSecond = getFromState[int64](db, cursorKey(DATE_START_SECOND_KEY), -1)
 	if r.startingSecond == -1 {
 		return DefaultRepoCreationDateRange()
 	}
-	r.howManySeconds = getFromState[int64](db, DATE_SECONDS_WINDOW_KEY, -1)
+	r.howManySeconds = getFromState[int64](db, cursorKey(DATE_SECONDS_WINDOW_KEY), -1)
 	if r.howManySeconds == -1 {
 		return DefaultRepoCreationDateRange()
 	}
 	return r
 }
 
+// bounds returns when the range starts and ends
+func (r RepoCreationDateRange) bounds() (start time.Time, end time.Time) {
+	start = githubCreationDay().Add(time.Duration(r.startingSecond) * time.Second)
+	end = start.Add(time.Duration(r.howManySeconds) * time.Second)
+	return start, end
+}
+
 func (r RepoCreationDateRange) ToQueryString() string {
-	start := githubCreationDay().Add(time.Duration(r.startingSecond) * time.Second)
-	end := start.Add(time.Duration(r.howManySeconds) * time.Second)
+	start, end := r.bounds()
 	return fmt.Sprintf("%v..%v", start.Format("2006-01-02T15:04:05Z"), end.Format("2006-01-02T15:04:05Z"))
 }
 
 func (r RepoCreationDateRange) Save(db xorm.Interface) {
 	// todo: transaction?
-	setToState[int64](db, DATE_START_SECOND_KEY, r.startingSecond)
-	setToState[int64](db, DATE_SECONDS_WINDOW_KEY, r.howManySeconds)
+	setCursor[int64](db, DATE_START_SECOND_KEY, r.startingSecond)
+	setCursor[int64](db, DATE_SECONDS_WINDOW_KEY, r.howManySeconds)
 }
 
 func (r RepoCreationDateRange) HalvedRange() (ret RepoCreationDateRange) {
@@ -139,3 +204,42 @@ func (r RepoCreationDateRange) NextRange() (ret RepoCreationDateRange) {
 	log.Printf("[creationDateRange] Going from %v to %v\n", r, ret)
 	return ret
 }
+
+// RepoPushedDateRange is a range of last push dates, splitting a slice of repositories further once neither
+// the star window nor the creation date range can be made any smaller
+type RepoPushedDateRange struct {
+	RepoCreationDateRange
+}
+
+// GetRepoPushedDateRange returns the current pushed date range, or false if slices aren't split by it
+func GetRepoPushedDateRange(db xorm.Interface) (r RepoPushedDateRange, active bool) {
+	r.startingSecond = getFromState[int64](db, cursorKey(PUSHED_START_SECOND_KEY), -1)
+	r.howManySeconds = getFromState[int64](db, cursorKey(PUSHED_SECONDS_WINDOW_KEY), -1)
+	if r.startingSecond == -1 || r.howManySeconds == -1 {
+		return RepoPushedDateRange{DefaultRepoCreationDateRange()}, false
+	}
+	return r, true
+}
+
+func (r RepoPushedDateRange) Save(db xorm.Interface) {
+	setCursor[int64](db, PUSHED_START_SECOND_KEY, r.startingSecond)
+	setCursor[int64](db, PUSHED_SECONDS_WINDOW_KEY, r.howManySeconds)
+}
+
+// ResetRepoPushedDateRange stops splitting slices by the pushed date
+func ResetRepoPushedDateRange(db xorm.Interface) {
+	setCursor[int64](db, PUSHED_START_SECOND_KEY, -1)
+	setCursor[int64](db, PUSHED_SECONDS_WINDOW_KEY, -1)
+}
+
+func (r RepoPushedDateRange) HalvedRange() RepoPushedDateRange {
+	return RepoPushedDateRange{r.RepoCreationDateRange.HalvedRange()}
+}
+
+func (r RepoPushedDateRange) BiggerRange() RepoPushedDateRange {
+	return RepoPushedDateRange{r.RepoCreationDateRange.BiggerRange()}
+}
+
+func (r RepoPushedDateRange) NextRange() RepoPushedDateRange {
+	return RepoPushedDateRange{r.RepoCreationDateRange.NextRange()}
+}
@@ -0,0 +1,129 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+// When the last pass over the hot tier started - kept in the state, so restarts don't redo it early
+const HOT_TIER_LAST_PASS_KEY = "hot_tier_last_pass"
+
+// Search requests made in this Run, and how many of them were for the hot tier
+var searchRequestsMade, hotTierRequestsMade atomic.Int64
+
+// hotTierQuery is one slice of the hot tier: the most starred repositories overall, or in a language
+type hotTierQuery struct {
+	// Empty for all languages
+	Language string
+}
+
+func (q hotTierQuery) String() string {
+	if q.Language == "" {
+		return "overall"
+	}
+	return q.Language
+}
+
+// Queries left in the current pass over the hot tier
+var hotTierQueue []hotTierQuery
+
+// hotTierLanguages returns the languages with the most repositories of at least config.MinimumStars
+func hotTierLanguages(db *xorm.Engine) []string {
+	var languages []string
+	lo.Must0(notGone(db.Table("Repo").
+		Where("Language != ''").
+		And("Stargazers >= ?", config.MinimumStars)).
+		GroupBy("Language").
+		OrderBy("count(*) DESC, Language ASC").
+		Limit(config.HotTierLanguages).
+		Cols("Language").
+		Find(&languages))
+	return languages
+}
+
+// hotTierMinimumStars returns how many stars the config.HotTierSize-th most starred repository (in a language,
+// if given) has, according to the database - enough for a search to return the whole slice
+func hotTierMinimumStars(db *xorm.Engine, language string) int64 {
+	session := notGone(db.Table("Repo"))
+	if language != "" {
+		session = session.And("Language = ?", language)
+	}
+
+	var stargazers []int64
+	lo.Must0(session.Desc("Stargazers").Limit(1, config.HotTierSize-1).Cols("Stargazers").Find(&stargazers))
+	if len(stargazers) == 0 || stargazers[0] < config.MinimumStars {
+		return config.MinimumStars
+	}
+	return stargazers[0]
+}
+
+func hotTierSearchTerms(db *xorm.Engine, query hotTierQuery) []string {
+	terms := []string{fmt.Sprintf("stars:>=%d", hotTierMinimumStars(db, query.Language))}
+	if query.Language != "" {
+		terms = append(terms, fmt.Sprintf(`language:"%s"`, query.Language))
+	}
+	return terms
+}
+
+// hotTierDue tells whether the hot tier should be searched before continuing the sweep: if a pass is in
+// progress or config.HotTierInterval passed since the last one, and the hot tier didn't use up more than
+// config.HotTierBudgetShare of search requests. In a sharded sweep, the worker crawling the top shard searches
+// for the hot tier.
+func hotTierDue(db *xorm.Engine) bool {
+	if config.HotTierSize <= 0 {
+		return false
+	}
+	if currentShard != nil && currentShard.MaxStars != MAX_STARS_DISCOVER {
+		return false
+	}
+	if float64(hotTierRequestsMade.Load()) > config.HotTierBudgetShare*float64(searchRequestsMade.Load()) {
+		return false
+	}
+	if len(hotTierQueue) > 0 {
+		return true
+	}
+
+	lastPass := time.Unix(getFromState[int64](db, HOT_TIER_LAST_PASS_KEY, 0), 0)
+	return time.Since(lastPass) >= config.HotTierInterval
+}
+
+// refreshHotTier searches for the next slice of the hot tier - starting a new pass if there's none in progress -
+// so star counts of the most visible repositories don't wait for the next full sweep
+func refreshHotTier(ctx context.Context, client *http.Client, db *xorm.Engine) {
+	if len(hotTierQueue) == 0 {
+		hotTierQueue = []hotTierQuery{{}}
+		for _, language := range hotTierLanguages(db) {
+			hotTierQueue = append(hotTierQueue, hotTierQuery{Language: language})
+		}
+		setToState(db, HOT_TIER_LAST_PASS_KEY, time.Now().Unix())
+		log.Printf("[hot-tier] Starting a pass over the top %d repositories overall and in %d languages\n", config.HotTierSize, len(hotTierQueue)-1)
+	}
+
+	query := hotTierQueue[0]
+	hotTierQueue = hotTierQueue[1:]
+
+	terms := hotTierSearchTerms(db, query)
+	maxPages := min(numberOfPages(int64(config.HotTierSize)), MAX_PAGES)
+	saved := 0
+
+	for page := 1; page <= maxPages; page++ {
+		hotTierRequestsMade.Add(1)
+		resp := search(client, page, terms...)
+		lo.Must0(resp.WaitIfNeccessary(ctx))
+		save(db, resp)
+		saved += len(resp.Items)
+
+		if page >= numberOfPages(resp.TotalCount) {
+			break
+		}
+	}
+
+	log.Printf("[hot-tier] Refreshed %d repositories (%s), %d slices left in this pass\n", saved, query, len(hotTierQueue))
+}
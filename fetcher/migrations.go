@@ -0,0 +1,216 @@
+package fetcher
+
+import (
+	"log"
+	"time"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+// migration is one step of evolving the database schema. Migrations are applied in order, each one in its
+// own transaction, and never changed once released - a schema change is always a new migration.
+type migration struct {
+	Version     int64
+	Description string
+	Up          func(session *xorm.Session) error
+}
+
+// SchemaVersion records every migration applied to the database
+type SchemaVersion struct {
+	Version     int64 `xorm:"pk notnull"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// execMigration returns a migration step running the given SQL statements
+func execMigration(statements ...string) func(session *xorm.Session) error {
+	return func(session *xorm.Session) error {
+		for _, statement := range statements {
+			if _, err := session.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		// The schema xorm's Sync created before migrations existed - "if not exists" makes this a no-op on
+		// databases from back then
+		Up: execMigration(
+			"create table if not exists `Repo` (`Id` INTEGER PRIMARY KEY NOT NULL, `Name` TEXT NULL, `FullName` TEXT NULL, `GithubLink` TEXT NULL, `Homepage` TEXT NULL, `Description` TEXT NULL, `Language` TEXT NULL, `Stargazers` INTEGER NULL, `Topics` TEXT NULL, `OpenIssues` INTEGER NULL, `Archived` INTEGER NULL, `CreatedAt` DATETIME NULL, `RepoPushedAt` DATETIME NULL, `RepoUpdatedAt` DATETIME NULL, `OwnerLogin` TEXT NULL, `OwnerAvatarUrl` TEXT NULL, `OwnerGravatarId` TEXT NULL, `OwnerType` TEXT NULL, `LicenseSpdxId` TEXT NULL, `LicenseName` TEXT NULL, `LastFetchedFromGithubAt` DATETIME NULL, `FirstFetchedFromGithubAt` DATETIME NULL, `GetRepoApiLastModifiedHeader` TEXT NULL, `NotSeenSinceCounter` INTEGER NULL)",
+			"create table if not exists `State` (`Name` TEXT PRIMARY KEY NOT NULL, `Value` TEXT NULL)",
+			"create unique index if not exists RepoNotSeenSinceCounter on Repo(NotSeenSinceCounter desc, Id asc)",
+			"create index if not exists RepoFullName on Repo(FullName)",
+		),
+	},
+	{
+		Version:     2,
+		Description: "record outcomes of verifying repositories",
+		Up: execMigration(
+			"alter table `Repo` add column `VerifiedAt` DATETIME NULL",
+			"alter table `Repo` add column `VerificationOutcome` TEXT NULL",
+		),
+	},
+	{
+		Version:     3,
+		Description: "keep the history of repository names",
+		Up: execMigration(
+			"create table `RepoNameHistory` (`Id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `RepoId` INTEGER NOT NULL, `OldFullName` TEXT NULL, `NewFullName` TEXT NULL, `ChangedAt` DATETIME NULL)",
+			"create index `IDX_RepoNameHistory_RepoId` on `RepoNameHistory` (`RepoId`)",
+		),
+	},
+	{
+		Version:     4,
+		Description: "tombstones of removed repositories",
+		Up: execMigration(
+			"alter table `Repo` add column `RemovedAt` DATETIME NULL",
+			// Gone repositories recorded before there was a timestamp
+			"update `Repo` set `RemovedAt` = `VerifiedAt` where `VerificationOutcome` in ('deleted', 'dmca', 'tos-disabled')",
+			"create index `RepoRemovedAt` on `Repo` (`RemovedAt`)",
+		),
+	},
+	{
+		Version:     5,
+		Description: "the rest of the fields returned by search",
+		// Filled in as repositories are fetched again
+		Up: execMigration(
+			"alter table `Repo` add column `Forks` INTEGER NULL",
+			"alter table `Repo` add column `Watchers` INTEGER NULL",
+			"alter table `Repo` add column `Size` INTEGER NULL",
+			"alter table `Repo` add column `Fork` INTEGER NULL",
+			"alter table `Repo` add column `IsTemplate` INTEGER NULL",
+			"alter table `Repo` add column `DefaultBranch` TEXT NULL",
+			"alter table `Repo` add column `HasPages` INTEGER NULL",
+			"alter table `Repo` add column `Visibility` TEXT NULL",
+		),
+	},
+	{
+		Version:     6,
+		Description: "details of repositories fetched by the enrichment worker",
+		Up: execMigration(
+			"create table `RepoEnrichment` (`RepoId` INTEGER PRIMARY KEY NOT NULL, `EnrichedAt` DATETIME NULL, `LanguagesETag` TEXT NULL, `LatestReleaseETag` TEXT NULL, `ContributorsETag` TEXT NULL)",
+			"create table `RepoLanguage` (`RepoId` INTEGER NOT NULL, `Language` TEXT NOT NULL, `Bytes` INTEGER NULL, PRIMARY KEY (`RepoId`, `Language`))",
+			"create table `RepoRelease` (`RepoId` INTEGER PRIMARY KEY NOT NULL, `TagName` TEXT NULL, `Name` TEXT NULL, `PublishedAt` DATETIME NULL)",
+			"create table `RepoContributors` (`RepoId` INTEGER PRIMARY KEY NOT NULL, `Count` INTEGER NULL)",
+		),
+	},
+	{
+		Version:     7,
+		Description: "GraphQL node IDs of repositories",
+		// Filled in as repositories are fetched again - until then, the refresher uses legacy node IDs
+		Up: execMigration(
+			"alter table `Repo` add column `NodeId` TEXT NULL",
+		),
+	},
+	{
+		Version:     8,
+		Description: "index for predicting search windows",
+		Up: execMigration(
+			"create index `RepoStargazersCreatedAt` on `Repo` (`Stargazers`, `CreatedAt`)",
+		),
+	},
+	{
+		Version:     9,
+		Description: "sweeps start at the most starred repository instead of 200000 stars",
+		// A sweep about to start at the old fixed ceiling discovers where to start instead
+		Up: execMigration(
+			"update `State` set `Value` = '9223372036854775807' where `Name` = 'max_stars' and `Value` = '200000'",
+		),
+	},
+	{
+		Version:     10,
+		Description: "shards of sweeps leased to workers",
+		Up: execMigration(
+			"create table `Shard` (`Id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `Sweep` INTEGER NOT NULL, `MinStars` INTEGER NOT NULL, `MaxStars` INTEGER NOT NULL, `LeaseOwner` TEXT NULL, `LeaseExpiresAt` DATETIME NULL, `DoneAt` DATETIME NULL)",
+			"create index `IDX_Shard_Sweep` on `Shard` (`Sweep`)",
+		),
+	},
+	{
+		Version:     11,
+		Description: "cycles which last found repositories instead of counters incremented every sweep",
+		// The current cycle starts at the biggest counter, so that how long repositories weren't seen stays the same
+		Up: execMigration(
+			"alter table `Repo` add column `LastSeenCycle` INTEGER NULL",
+			"insert or replace into `State` (`Name`, `Value`) values ('cycle', (select cast(coalesce(max(`NotSeenSinceCounter`), 0) as text) from `Repo`))",
+			"update `Repo` set `LastSeenCycle` = (select coalesce(max(`NotSeenSinceCounter`), 0) from `Repo`) - coalesce(`NotSeenSinceCounter`, 0)",
+			// The apifier's view uses the counter - it's created anew on the apifier's next run
+			"drop view if exists `ActiveRepo`",
+			// Left behind by an apifier which didn't finish
+			"drop index if exists `LanguageStargazersId`",
+			"drop index if exists `StargazersId`",
+			"drop index `RepoNotSeenSinceCounter`",
+			"alter table `Repo` drop column `NotSeenSinceCounter`",
+			"create index `RepoLastSeenCycle` on `Repo` (`LastSeenCycle`, `Id`)",
+		),
+	},
+	{
+		Version:     12,
+		Description: "case-insensitive index of repository names",
+		// GitHub's names are case-insensitive - the apifier looks up who holds an old name of a renamed repository
+		Up: execMigration(
+			"create index `RepoFullNameNocase` on `Repo` (`FullName` collate nocase)",
+		),
+	},
+	{
+		Version:     13,
+		Description: "backoff of repositories which failed to be enriched",
+		Up: execMigration(
+			"alter table `RepoEnrichment` add column `Failures` INTEGER NULL",
+			"alter table `RepoEnrichment` add column `RetryAfter` DATETIME NULL",
+		),
+	},
+}
+
+func currentSchemaVersion(engine *xorm.Engine) int64 {
+	var version int64
+	lo.Must(engine.SQL("select coalesce(max(Version), 0) from SchemaVersion").Get(&version))
+	return version
+}
+
+func applyMigration(engine *xorm.Engine, m migration) error {
+	session := engine.NewSession()
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+
+	if err := m.Up(session); err != nil {
+		return err
+	}
+
+	_, err := session.Insert(&SchemaVersion{Version: m.Version, Description: m.Description, AppliedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	return session.Commit()
+}
+
+// migrate brings the database schema up to date, applying all migrations newer than its current version
+func migrate(engine *xorm.Engine) {
+	lo.Must0(engine.Sync(new(SchemaVersion)))
+
+	version := currentSchemaVersion(engine)
+	latest := migrations[len(migrations)-1].Version
+	if version > latest {
+		log.Panicf("The database schema is at version %d, newer than this fetcher knows about (%d)\n", version, latest)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		log.Printf("[migrations] Migrating the database to version %d: %s\n", m.Version, m.Description)
+		err := applyMigration(engine, m)
+		if err != nil {
+			log.Panicf("Migration to version %d (%s) failed: %v\n", m.Version, m.Description, err)
+		}
+	}
+}
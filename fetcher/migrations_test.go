@@ -0,0 +1,175 @@
+package fetcher
+
+import (
+	"path/filepath"
+	"testing"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+// useTestDb points the fetcher at a new database in a temporary directory
+func useTestDb(t testing.TB) string {
+	t.Helper()
+	previous := config
+	t.Cleanup(func() { config = previous })
+
+	config = DefaultConfig()
+	config.DatabasePath = filepath.Join(t.TempDir(), "repos.db")
+	return config.DatabasePath
+}
+
+// openRawDb opens the database without migrating it, for setting up fixtures
+func openRawDb(t *testing.T, path string) *xorm.Engine {
+	t.Helper()
+	engine, err := xorm.NewEngine("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetMapper(names.SameMapper{})
+	return engine
+}
+
+func mustExec(t *testing.T, db *xorm.Engine, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+}
+
+func queryInt(t *testing.T, db *xorm.Engine, query string, args ...any) int64 {
+	t.Helper()
+	var value int64
+	if _, err := db.SQL(query, args...).Get(&value); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return value
+}
+
+func TestMigrationDropsActiveRepoView(t *testing.T) {
+	path := useTestDb(t)
+
+	// A database migrated up to just before cycles, after the apifier created its view
+	allMigrations := migrations
+	migrations = allMigrations[:10]
+	db := initialiseDb()
+	migrations = allMigrations
+	mustExec(t, db,
+		"insert into Repo (Id, FullName, Stargazers, NotSeenSinceCounter) values (1, 'o/r', 10, 2)",
+		"create view ActiveRepo as select Id, Stargazers, coalesce(NotSeenSinceCounter, 0) > 15 as Stale from Repo",
+	)
+	db.Close()
+
+	config.DatabasePath = path
+	db = initialiseDb()
+	defer db.Close()
+
+	if version, latest := currentSchemaVersion(db), migrations[len(migrations)-1].Version; version != latest {
+		t.Fatalf("expected schema version %d, got %d", latest, version)
+	}
+	if views := queryInt(t, db, "select count(*) from sqlite_master where type = 'view' and name = 'ActiveRepo'"); views != 0 {
+		t.Errorf("expected the ActiveRepo view to be dropped")
+	}
+	if lastSeen := queryInt(t, db, "select LastSeenCycle from Repo where Id = 1"); lastSeen != 0 {
+		t.Errorf("expected the repo to be last seen in cycle 0, got %d", lastSeen)
+	}
+}
+
+// A database from before migrations existed, as xorm's Sync and the apifier left it
+func createBaselineFixture(t *testing.T, path string) {
+	t.Helper()
+	db := openRawDb(t, path)
+	defer db.Close()
+
+	mustExec(t, db,
+		"create table `Repo` (`Id` INTEGER PRIMARY KEY NOT NULL, `Name` TEXT NULL, `FullName` TEXT NULL, `GithubLink` TEXT NULL, `Homepage` TEXT NULL, `Description` TEXT NULL, `Language` TEXT NULL, `Stargazers` INTEGER NULL, `Topics` TEXT NULL, `OpenIssues` INTEGER NULL, `Archived` INTEGER NULL, `CreatedAt` DATETIME NULL, `RepoPushedAt` DATETIME NULL, `RepoUpdatedAt` DATETIME NULL, `OwnerLogin` TEXT NULL, `OwnerAvatarUrl` TEXT NULL, `OwnerGravatarId` TEXT NULL, `OwnerType` TEXT NULL, `LicenseSpdxId` TEXT NULL, `LicenseName` TEXT NULL, `LastFetchedFromGithubAt` DATETIME NULL, `FirstFetchedFromGithubAt` DATETIME NULL, `GetRepoApiLastModifiedHeader` TEXT NULL, `NotSeenSinceCounter` INTEGER NULL)",
+		"create table `State` (`Name` TEXT PRIMARY KEY NOT NULL, `Value` TEXT NULL)",
+		"create unique index RepoNotSeenSinceCounter on Repo(NotSeenSinceCounter desc, Id asc)",
+		"create index RepoFullName on Repo(FullName)",
+		"create index LanguageStargazersId on Repo(Language, Stargazers DESC, Id, NotSeenSinceCounter)",
+		"create index StargazersId on Repo(Stargazers DESC, Id, NotSeenSinceCounter)",
+		"create view ActiveRepo as select Id, Stargazers, coalesce(NotSeenSinceCounter, 0) > 15 as Stale from Repo",
+		"insert into State (Name, Value) values ('max_stars', '200000')",
+		"insert into Repo (Id, FullName, Stargazers, NotSeenSinceCounter) values (1, 'o/seen', 100, 0), (2, 'o/missed-once', 50, 1), (3, 'o/missed-thrice', 20, 3), (4, 'o/never-counted', 10, NULL)",
+	)
+}
+
+func TestMigrateBaselineFixture(t *testing.T) {
+	path := useTestDb(t)
+	createBaselineFixture(t, path)
+
+	db := initialiseDb()
+	defer db.Close()
+
+	latest := migrations[len(migrations)-1].Version
+	if version := currentSchemaVersion(db); version != latest {
+		t.Fatalf("expected schema version %d, got %d", latest, version)
+	}
+	var applied []SchemaVersion
+	if err := db.Asc("Version").Find(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(applied)) != latest {
+		t.Fatalf("expected %d applied migrations, got %d", latest, len(applied))
+	}
+	for i, version := range applied {
+		if version.Version != int64(i+1) || version.Description != migrations[i].Description || version.AppliedAt.IsZero() {
+			t.Errorf("unexpected SchemaVersion row %+v", version)
+		}
+	}
+
+	columns := map[string]bool{}
+	var columnNames []string
+	if err := db.SQL("select name from pragma_table_info('Repo')").Find(&columnNames); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range columnNames {
+		columns[name] = true
+	}
+	table, err := db.TableInfo(&Repo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, column := range table.ColumnsSeq() {
+		if !columns[column] {
+			t.Errorf("expected Repo to have the column %s", column)
+		}
+	}
+	if columns["NotSeenSinceCounter"] {
+		t.Errorf("expected NotSeenSinceCounter to be dropped")
+	}
+
+	for _, table := range []string{"RepoNameHistory", "RepoEnrichment", "RepoLanguage", "RepoRelease", "RepoContributors", "Shard"} {
+		if queryInt(t, db, "select count(*) from sqlite_master where type = 'table' and name = ?", table) != 1 {
+			t.Errorf("expected the table %s to be created", table)
+		}
+	}
+	for index, expected := range map[string]int64{
+		"RepoFullName":            1,
+		"RepoRemovedAt":           1,
+		"RepoStargazersCreatedAt": 1,
+		"RepoLastSeenCycle":       1,
+		"RepoFullNameNocase":      1,
+		"RepoNotSeenSinceCounter": 0,
+		"LanguageStargazersId":    0,
+		"StargazersId":            0,
+	} {
+		if queryInt(t, db, "select count(*) from sqlite_master where type = 'index' and name = ?", index) != expected {
+			t.Errorf("expected %d of the index %s", expected, index)
+		}
+	}
+
+	if cycle := GetCycle(db); cycle != 3 {
+		t.Errorf("expected the cycle to start at the biggest counter, 3, got %d", cycle)
+	}
+	for id, expected := range map[int64]int64{1: 3, 2: 2, 3: 0, 4: 3} {
+		if lastSeen := queryInt(t, db, "select LastSeenCycle from Repo where Id = ?", id); lastSeen != expected {
+			t.Errorf("expected repo %d to be last seen in cycle %d, got %d", id, expected, lastSeen)
+		}
+	}
+	if maxStars := GetMaxStars(db); maxStars != MAX_STARS_DISCOVER {
+		t.Errorf("expected the old max stars ceiling to be replaced, got %d", maxStars)
+	}
+}
@@ -1,9 +1,10 @@
-package main
+package fetcher
 
 import "time"
 
 type Repo struct {
 	Id            int64     `json:"id" xorm:"pk notnull"`
+	NodeId        string    `json:"node_id"`
 	Name          string    `json:"name"`
 	FullName      string    `json:"full_name"`
 	GithubLink    string    `json:"html_url"`
@@ -14,6 +15,14 @@ type Repo struct {
 	Topics        []string  `json:"topics"`
 	OpenIssues    int64     `json:"open_issues_count"`
 	Archived      bool      `json:"archived"`
+	Forks         int64     `json:"forks_count"`
+	Watchers      int64     `json:"watchers_count"`
+	Size          int64     `json:"size"`
+	Fork          bool      `json:"fork"`
+	IsTemplate    bool      `json:"is_template"`
+	DefaultBranch string    `json:"default_branch"`
+	HasPages      bool      `json:"has_pages"`
+	Visibility    string    `json:"visibility"`
 	CreatedAt     time.Time `json:"created_at"`
 	RepoPushedAt  time.Time `json:"pushed_at"`
 	RepoUpdatedAt time.Time `json:"updated_at"`
@@ -33,10 +42,26 @@ type Repo struct {
 
 	GetRepoApiLastModifiedHeader string `json:"-"`
 
-	NotSeenSinceCounter int64 `json:"-"`
+	// The sweep which last found the repo - it's not been seen for GetCycle() - LastSeenCycle sweeps
+	LastSeenCycle int64 `json:"-"`
+
+	// When the verifier last checked the repo, and what it found - see OUTCOME_*
+	VerifiedAt          time.Time `json:"-"`
+	VerificationOutcome string    `json:"-"`
+	// When the repo was found to be gone - see GONE_OUTCOMES
+	RemovedAt time.Time `json:"-"`
 }
 
 type State struct {
 	Name  string `xorm:"pk notnull"`
 	Value string
 }
+
+// RepoNameHistory records every rename or transfer of a repository - the Id stays the same, the FullName changes
+type RepoNameHistory struct {
+	Id          int64 `xorm:"pk autoincr"`
+	RepoId      int64 `xorm:"index notnull"`
+	OldFullName string
+	NewFullName string
+	ChangedAt   time.Time
+}
@@ -0,0 +1,160 @@
+package fetcher
+
+import (
+	"log"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+const (
+	// How many results a predicted search should have - a page short of the most search returns, as
+	// repositories gain stars and get created between sweeps
+	PREDICTION_TARGET_RESULTS = MAX_RESULTS_PER_PAGE * (MAX_PAGES - 1)
+	// Search requests saved by predicting windows in the current sweep
+	PREDICTION_SAVED_REQUESTS_KEY = "prediction_saved_requests"
+)
+
+// A predicted search for this many stars returned too many results, because the database is out of date -
+// until maxStars changes, the window is sized like without predictions
+var predictionOverflowedAt int64 = -1
+
+// countReposWithStars returns how many repositories with minStars..maxStars stars the previous sweep found
+func countReposWithStars(db *xorm.Engine, minStars int64, maxStars int64) int64 {
+	return lo.Must(notGone(db.Where("Stargazers BETWEEN ? AND ?", minStars, maxStars)).Count(&Repo{}))
+}
+
+// countReposCreatedBetween returns how many repositories with exactly this many stars were created in the range
+func countReposCreatedBetween(db *xorm.Engine, stars int64, creation RepoCreationDateRange) int64 {
+	start, end := creation.bounds()
+	return lo.Must(notGone(db.Where("Stargazers = ?", stars).
+		And("CreatedAt BETWEEN ? AND ?", start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"))).
+		Count(&Repo{}))
+}
+
+// predictSearchWindow returns the biggest star window below maxStars expected to have at most
+// PREDICTION_TARGET_RESULTS results, according to the star counts the database has. Returns false if the
+// database doesn't have a whole sweep to predict with.
+func predictSearchWindow(db *xorm.Engine, maxStars int64) (window int64, ok bool) {
+	// Until a whole sweep is done, the database only has some of the repositories
+	if getFromState[int64](db, MINIMUM_STARS_KEY, -1) == -1 || countReposWithStars(db, sweepFloor(), maxStars) == 0 {
+		return 0, false
+	}
+
+	// Stars of the first repository which wouldn't fit anymore
+	var stargazers []int64
+	lo.Must0(notGone(db.Table("Repo").Where("Stargazers <= ?", maxStars)).
+		Desc("Stargazers").
+		Limit(1, PREDICTION_TARGET_RESULTS).
+		Cols("Stargazers").
+		Find(&stargazers))
+
+	if len(stargazers) == 0 || stargazers[0] < sweepFloor() {
+		// Everything left fits in a single search
+		return max(maxStars-sweepFloor(), 0), true
+	}
+	return max(maxStars-stargazers[0]-1, 0), true
+}
+
+// predictDateRange returns the biggest creation date range, starting where creation does, expected to have
+// at most PREDICTION_TARGET_RESULTS repositories with exactly this many stars
+func predictDateRange(db *xorm.Engine, stars int64, creation RepoCreationDateRange) RepoCreationDateRange {
+	start, _ := creation.bounds()
+
+	// The first repository which wouldn't fit anymore
+	var repos []Repo
+	lo.Must0(notGone(db.Where("Stargazers = ?", stars).And("CreatedAt >= ?", start.Format("2006-01-02 15:04:05"))).
+		Asc("CreatedAt").
+		Limit(1, PREDICTION_TARGET_RESULTS).
+		Cols("CreatedAt").
+		Find(&repos))
+
+	predicted := RepoCreationDateRange{startingSecond: creation.startingSecond}
+	if len(repos) == 0 {
+		// Everything up to today fits
+		predicted.howManySeconds = max(DefaultRepoCreationDateRange().howManySeconds-creation.startingSecond, 0)
+	} else {
+		predicted.howManySeconds = max(int64(repos[0].CreatedAt.Sub(start).Seconds())-1, 0)
+	}
+	return predicted
+}
+
+// savedByPredictedWindow estimates how many search requests sizing the window blindly would have taken more:
+// first pages which overflow and have to be retried with a halved window, or more searches covering the same
+// stars with a window too small
+func savedByPredictedWindow(db *xorm.Engine, maxStars int64, blindWindow int64, predictedWindow int64) int64 {
+	blindCount := countReposWithStars(db, maxStars-blindWindow, maxStars)
+	predictedCount := countReposWithStars(db, maxStars-predictedWindow, maxStars)
+
+	if blindCount > MAX_RESULTS_PER_PAGE*MAX_PAGES {
+		saved := int64(0)
+		for window := blindWindow; window > predictedWindow && countReposWithStars(db, maxStars-window, maxStars) > MAX_RESULTS_PER_PAGE*MAX_PAGES; window = smallerWindow(window) {
+			saved++
+		}
+		return saved
+	}
+
+	if blindCount < predictedCount {
+		searches := (predictedCount + max(blindCount, 1) - 1) / max(blindCount, 1)
+		blindRequests := searches * int64(max(numberOfPages(blindCount), 1))
+		return max(blindRequests-int64(max(numberOfPages(predictedCount), 1)), 0)
+	}
+
+	return 0
+}
+
+// savedByPredictedDateRange estimates how many first pages halving the date range blindly would have taken
+func savedByPredictedDateRange(db *xorm.Engine, stars int64, blind RepoCreationDateRange, predicted RepoCreationDateRange) int64 {
+	saved := int64(0)
+	for creation := blind; creation.howManySeconds > predicted.howManySeconds && countReposCreatedBetween(db, stars, creation) > MAX_RESULTS_PER_PAGE*MAX_PAGES; creation = creation.HalvedRange() {
+		saved++
+	}
+	return saved
+}
+
+// applyPredictions sizes the star window - and the creation date range, if even a single star count has too
+// many repositories - to what the previous sweep found, so that searches land just under the 1000 results
+// limit. Search requests saved compared to sizing them blindly are added up for the sweep.
+func applyPredictions(db *xorm.Engine, maxStars int64, searchWindow int64, creationDateRange RepoCreationDateRange) (int64, RepoCreationDateRange) {
+	if maxStars == predictionOverflowedAt {
+		return searchWindow, creationDateRange
+	}
+
+	predictedWindow, ok := predictSearchWindow(db, maxStars)
+	if !ok {
+		return searchWindow, creationDateRange
+	}
+
+	saved := int64(0)
+	if predictedWindow != searchWindow {
+		saved += savedByPredictedWindow(db, maxStars, searchWindow, predictedWindow)
+		log.Printf("[prediction] Setting the window size to %v instead of %v, expecting %d results\n",
+			predictedWindow, searchWindow, countReposWithStars(db, maxStars-predictedWindow, maxStars))
+		SetSearchWindow(db, predictedWindow)
+		searchWindow = predictedWindow
+	}
+
+	if predictedWindow == 0 && countReposWithStars(db, maxStars, maxStars) > PREDICTION_TARGET_RESULTS {
+		predictedDateRange := predictDateRange(db, maxStars, creationDateRange)
+		if predictedDateRange != creationDateRange {
+			saved += savedByPredictedDateRange(db, maxStars, creationDateRange, predictedDateRange)
+			log.Printf("[prediction] Setting the creation date range to %v instead of %v, expecting %d results\n",
+				predictedDateRange.ToQueryString(), creationDateRange.ToQueryString(), countReposCreatedBetween(db, maxStars, predictedDateRange))
+			predictedDateRange.Save(db)
+			creationDateRange = predictedDateRange
+		}
+	}
+
+	if saved > 0 {
+		setToState(db, PREDICTION_SAVED_REQUESTS_KEY, getFromState[int64](db, PREDICTION_SAVED_REQUESTS_KEY, 0)+saved)
+	}
+	return searchWindow, creationDateRange
+}
+
+// reportPredictions logs how many search requests predicting windows saved in the sweep, and starts counting
+// anew for the next one
+func reportPredictions(db *xorm.Engine) {
+	saved := getFromState[int64](db, PREDICTION_SAVED_REQUESTS_KEY, 0)
+	log.Printf("[prediction] Sizing search windows from the previous sweep saved about %d search requests in this sweep\n", saved)
+	setToState[int64](db, PREDICTION_SAVED_REQUESTS_KEY, 0)
+}
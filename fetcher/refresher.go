@@ -0,0 +1,338 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+const (
+	// How many repositories to refresh with a single GraphQL request - the most nodes() accepts
+	REFRESHER_BATCH_SIZE = 100
+	// GraphQL rate limit points left for everything else
+	REFRESHER_RATELIMIT_RESERVE = 100
+	// How long a single round of refreshing can take, so searching isn't held up for too long
+	REFRESHER_TIME_BUDGET = 2 * time.Minute
+	// Repositories not fetched for this long get refreshed
+	REFRESHER_REFRESH_AFTER = 24 * time.Hour
+)
+
+// The fields of a repository search returns, in GraphQL. Watchers are stargazers, like the REST API's
+// watchers_count, and open issues include pull requests, like open_issues_count.
+const refreshQuery = `query($ids: [ID!]!) {
+  rateLimit { cost remaining resetAt }
+  nodes(ids: $ids) {
+    ... on Repository {
+      id
+      name
+      nameWithOwner
+      url
+      homepageUrl
+      description
+      primaryLanguage { name }
+      stargazerCount
+      repositoryTopics(first: 20) { nodes { topic { name } } }
+      issues(states: OPEN) { totalCount }
+      pullRequests(states: OPEN) { totalCount }
+      isArchived
+      forkCount
+      diskUsage
+      isFork
+      isTemplate
+      defaultBranchRef { name }
+      visibility
+      createdAt
+      pushedAt
+      updatedAt
+      owner { __typename login avatarUrl }
+      licenseInfo { spdxId name }
+    }
+  }
+}`
+
+type graphqlRepository struct {
+	Id              string `json:"id"`
+	Name            string `json:"name"`
+	NameWithOwner   string `json:"nameWithOwner"`
+	Url             string `json:"url"`
+	HomepageUrl     string `json:"homepageUrl"`
+	Description     string `json:"description"`
+	PrimaryLanguage *struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	StargazerCount   int64 `json:"stargazerCount"`
+	RepositoryTopics struct {
+		Nodes []graphqlRepositoryTopic `json:"nodes"`
+	} `json:"repositoryTopics"`
+	Issues struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"issues"`
+	PullRequests struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"pullRequests"`
+	IsArchived       bool  `json:"isArchived"`
+	ForkCount        int64 `json:"forkCount"`
+	DiskUsage        int64 `json:"diskUsage"`
+	IsFork           bool  `json:"isFork"`
+	IsTemplate       bool  `json:"isTemplate"`
+	DefaultBranchRef *struct {
+		Name string `json:"name"`
+	} `json:"defaultBranchRef"`
+	Visibility string    `json:"visibility"`
+	CreatedAt  time.Time `json:"createdAt"`
+	PushedAt   time.Time `json:"pushedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	Owner      struct {
+		Typename  string `json:"__typename"`
+		Login     string `json:"login"`
+		AvatarUrl string `json:"avatarUrl"`
+	} `json:"owner"`
+	LicenseInfo *struct {
+		SpdxId string `json:"spdxId"`
+		Name   string `json:"name"`
+	} `json:"licenseInfo"`
+}
+
+type graphqlRepositoryTopic struct {
+	Topic struct {
+		Name string `json:"name"`
+	} `json:"topic"`
+}
+
+type graphqlError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Path    []any  `json:"path"`
+}
+
+type graphqlRefreshResponse struct {
+	Data *struct {
+		RateLimit struct {
+			Cost      int       `json:"cost"`
+			Remaining int       `json:"remaining"`
+			ResetAt   time.Time `json:"resetAt"`
+		} `json:"rateLimit"`
+		Nodes []*graphqlRepository `json:"nodes"`
+	} `json:"data"`
+	Errors []graphqlError `json:"errors"`
+}
+
+// notFoundNodes returns indices of nodes GraphQL couldn't resolve - the repositories are gone
+func (resp graphqlRefreshResponse) notFoundNodes() map[int]bool {
+	notFound := map[int]bool{}
+	for _, err := range resp.Errors {
+		if err.Type != "NOT_FOUND" || len(err.Path) != 2 || err.Path[0] != "nodes" {
+			continue
+		}
+		if index, ok := err.Path[1].(float64); ok {
+			notFound[int(index)] = true
+		}
+	}
+	return notFound
+}
+
+// legacyNodeId is the node ID GitHub used to give repositories, still accepted by the GraphQL API - for
+// repositories saved before their node IDs were
+func legacyNodeId(repoId int64) string {
+	return base64.StdEncoding.EncodeToString([]byte("010:Repository" + strconv.FormatInt(repoId, 10)))
+}
+
+func nodeId(repo Repo) string {
+	if repo.NodeId != "" {
+		return repo.NodeId
+	}
+	return legacyNodeId(repo.Id)
+}
+
+// refreshed is the previously saved repository updated with what GraphQL returned. Fields GraphQL doesn't
+// have, like HasPages, are left as they were.
+func (node graphqlRepository) refreshed(previous Repo) Repo {
+	repo := previous
+	repo.NodeId = node.Id
+	repo.Name = node.Name
+	repo.FullName = node.NameWithOwner
+	repo.GithubLink = node.Url
+	repo.Homepage = node.HomepageUrl
+	repo.Description = node.Description
+	repo.Language = ""
+	if node.PrimaryLanguage != nil {
+		repo.Language = node.PrimaryLanguage.Name
+	}
+	repo.Stargazers = node.StargazerCount
+	repo.Topics = lo.Map(node.RepositoryTopics.Nodes, func(topic graphqlRepositoryTopic, index int) string {
+		return topic.Topic.Name
+	})
+	repo.OpenIssues = node.Issues.TotalCount + node.PullRequests.TotalCount
+	repo.Archived = node.IsArchived
+	repo.Forks = node.ForkCount
+	repo.Watchers = node.StargazerCount
+	repo.Size = node.DiskUsage
+	repo.Fork = node.IsFork
+	repo.IsTemplate = node.IsTemplate
+	repo.DefaultBranch = ""
+	if node.DefaultBranchRef != nil {
+		repo.DefaultBranch = node.DefaultBranchRef.Name
+	}
+	repo.Visibility = strings.ToLower(node.Visibility)
+	repo.CreatedAt = node.CreatedAt
+	repo.RepoPushedAt = node.PushedAt
+	repo.RepoUpdatedAt = node.UpdatedAt
+	repo.Owner.Login = node.Owner.Login
+	repo.Owner.AvatarUrl = node.Owner.AvatarUrl
+	repo.Owner.Type = node.Owner.Typename
+	repo.License.SpdxId, repo.License.Name = "", ""
+	if node.LicenseInfo != nil {
+		repo.License.SpdxId = node.LicenseInfo.SpdxId
+		repo.License.Name = node.LicenseInfo.Name
+	}
+	return repo
+}
+
+func refreshBatch(githubClient *http.Client, repos []Repo) (resp graphqlRefreshResponse, err error) {
+	body := lo.Must(json.Marshal(map[string]any{
+		"query":     refreshQuery,
+		"variables": map[string]any{"ids": lo.Map(repos, func(repo Repo, index int) string { return nodeId(repo) })},
+	}))
+	req := lo.Must(http.NewRequest("POST", config.GraphqlApiUrl, bytes.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	if config.EnableRequestLog {
+		reqLogger.Println(string(lo.Must(httputil.DumpRequest(req, false))))
+	}
+
+	response, err := githubClient.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer response.Body.Close()
+
+	if config.EnableResponsesLog {
+		resLogger.Println(string(lo.Must(httputil.DumpResponse(response, false))))
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return resp, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("received response code %s: %s", response.Status, string(responseBody))
+	}
+
+	if err := json.Unmarshal(responseBody, &resp); err != nil {
+		return resp, err
+	}
+	if resp.Data == nil || len(resp.Data.Nodes) != len(repos) {
+		return resp, fmt.Errorf("no nodes in the response, errors: %+v", resp.Errors)
+	}
+	return resp, nil
+}
+
+// getReposToRefresh returns the repositories fetched the longest time ago, skipping gone ones
+func getReposToRefresh(db *xorm.Engine, howMany int, skip []int64) (repos []Repo) {
+	refreshBefore := time.Now().Add(-REFRESHER_REFRESH_AFTER).Format("2006-01-02 15:04:05")
+	lo.Must0(notGone(inCurrentShard(db.Where("Stargazers >= ?", config.MinimumStars), "Stargazers").
+		And("LastFetchedFromGithubAt < ?", refreshBefore)).
+		NotIn("Id", lo.ToAnySlice(skip)...).
+		Asc("LastFetchedFromGithubAt").
+		Asc("Id").
+		Limit(howMany).
+		Find(&repos))
+
+	return repos
+}
+
+// saveRefreshed saves refreshed repositories through save, like search results, and tombstones the ones
+// GraphQL couldn't find. Returns IDs of repositories which failed to refresh.
+func saveRefreshed(db *xorm.Engine, repos []Repo, resp graphqlRefreshResponse, counts map[string]int) (failed []int64) {
+	notFound := resp.notFoundNodes()
+	refreshed := []Repo{}
+
+	for i, node := range resp.Data.Nodes {
+		previous := repos[i]
+		switch {
+		case node != nil:
+			repo := node.refreshed(previous)
+			repo.VerifiedAt = time.Now()
+			repo.VerificationOutcome = OUTCOME_EXISTS
+			if !strings.EqualFold(repo.FullName, previous.FullName) {
+				repo.VerificationOutcome = OUTCOME_MOVED
+			}
+			counts[repo.VerificationOutcome]++
+			refreshed = append(refreshed, repo)
+		case notFound[i]:
+			log.Printf("[refresher] %s is gone: %s\n", previous.FullName, OUTCOME_DELETED)
+			lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
+				lo.Must(tx.ID(previous.Id).Cols("VerifiedAt").Update(&Repo{VerifiedAt: time.Now()}))
+				tombstone(tx, previous.Id, OUTCOME_DELETED)
+				return nil, nil
+			}))
+			counts[OUTCOME_DELETED]++
+		default:
+			failed = append(failed, previous.Id)
+		}
+	}
+
+	save(db, GithubSearchResponse{TotalCount: int64(len(refreshed)), Items: refreshed})
+	return failed
+}
+
+// availableGraphqlRatelimit returns how many GraphQL rate limit points the refresher can still use
+func availableGraphqlRatelimit(db *xorm.Engine) int {
+	ratelimitReset, ratelimitRemaining := GetGraphqlRatelimit(db)
+	if time.Until(ratelimitReset) <= -3*time.Second {
+		// The rate limit was reset since it was last seen
+		return DEFAULT_GRAPHQL_LIMIT - REFRESHER_RATELIMIT_RESERVE
+	}
+	return ratelimitRemaining - REFRESHER_RATELIMIT_RESERVE
+}
+
+// refreshRepos refreshes repositories not fetched for REFRESHER_REFRESH_AFTER in batches of
+// REFRESHER_BATCH_SIZE through the GraphQL API, which has its own rate limit, until they're all refreshed,
+// the rate limit runs out, or REFRESHER_TIME_BUDGET passes
+func refreshRepos(ctx context.Context, githubApiClient *http.Client, db *xorm.Engine) {
+	startedAt := time.Now()
+	counts := map[string]int{}
+	failed := []int64{}
+	cost := 0
+
+	for ctx.Err() == nil && time.Since(startedAt) < REFRESHER_TIME_BUDGET {
+		if availableGraphqlRatelimit(db) <= 0 {
+			ratelimitReset, _ := GetGraphqlRatelimit(db)
+			log.Printf("[refresher] Out of rate limit, not refreshing repositories for the next %d seconds\n", time.Until(ratelimitReset)/time.Second)
+			break
+		}
+
+		toRefresh := getReposToRefresh(db, REFRESHER_BATCH_SIZE, failed)
+		if len(toRefresh) == 0 {
+			break
+		}
+
+		resp, err := refreshBatch(githubApiClient, toRefresh)
+		if err != nil {
+			log.Printf("[refresher] Could not refresh %d repositories: %v\n", len(toRefresh), err)
+			break
+		}
+
+		failed = append(failed, saveRefreshed(db, toRefresh, resp, counts)...)
+
+		cost += resp.Data.RateLimit.Cost
+		SetGraphqlRatelimit(db, resp.Data.RateLimit.ResetAt, resp.Data.RateLimit.Remaining)
+	}
+
+	if len(counts) > 0 || len(failed) > 0 {
+		log.Printf("[refresher] Done refreshing repos in %v for %d points: %d exist, %d moved, %d deleted, %d failed\n",
+			time.Since(startedAt).Round(time.Second), cost, counts[OUTCOME_EXISTS], counts[OUTCOME_MOVED], counts[OUTCOME_DELETED], len(failed))
+	}
+}
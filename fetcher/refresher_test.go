@@ -0,0 +1,130 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A batch of four repositories: one as it was, one renamed, one GitHub couldn't find, and one that didn't come
+// back for no reason
+const refreshTestResponse = `{
+  "data": {
+    "rateLimit": {"cost": 1, "remaining": 4999, "resetAt": "2026-01-01T00:00:00Z"},
+    "nodes": [
+      {
+        "id": "R_found", "name": "found", "nameWithOwner": "octo/found", "url": "https://github.com/octo/found",
+        "stargazerCount": 120, "primaryLanguage": {"name": "Go"}, "issues": {"totalCount": 2}, "pullRequests": {"totalCount": 1},
+        "visibility": "PUBLIC", "createdAt": "2015-01-01T00:00:00Z", "pushedAt": "2025-12-01T00:00:00Z", "updatedAt": "2025-12-02T00:00:00Z",
+        "owner": {"__typename": "Organization", "login": "octo", "avatarUrl": "https://avatars.example/octo"}
+      },
+      {
+        "id": "R_renamed", "name": "new-name", "nameWithOwner": "octo/new-name", "url": "https://github.com/octo/new-name",
+        "stargazerCount": 60, "visibility": "PUBLIC", "createdAt": "2016-01-01T00:00:00Z", "pushedAt": "2025-12-01T00:00:00Z", "updatedAt": "2025-12-02T00:00:00Z",
+        "owner": {"__typename": "User", "login": "octo", "avatarUrl": "https://avatars.example/octo"}
+      },
+      null,
+      null
+    ]
+  },
+  "errors": [
+    {"type": "NOT_FOUND", "path": ["nodes", 2], "message": "Could not resolve to a node with the global id of 'R_gone'."}
+  ]
+}`
+
+func TestRefreshBatch(t *testing.T) {
+	useTestDb(t)
+
+	var requestedIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Query     string `json:"query"`
+			Variables struct {
+				Ids []string `json:"ids"`
+			} `json:"variables"`
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON POST request, got %s with %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("could not decode the request: %v", err)
+		}
+		if request.Query != refreshQuery {
+			t.Errorf("expected the refresh query, got %q", request.Query)
+		}
+		requestedIds = request.Variables.Ids
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(refreshTestResponse))
+	}))
+	defer server.Close()
+	config.GraphqlApiUrl = server.URL
+
+	db := initialiseDb()
+	defer db.Close()
+	defer closeUpsertStatements(db)
+
+	lastFetched := time.Now().Add(-2 * REFRESHER_REFRESH_AFTER)
+	repos := []Repo{
+		{Id: 1, NodeId: "R_found", Name: "found", FullName: "octo/found", Stargazers: 100, LastFetchedFromGithubAt: lastFetched},
+		{Id: 2, NodeId: "R_renamed", Name: "old-name", FullName: "octo/old-name", Stargazers: 50, LastFetchedFromGithubAt: lastFetched},
+		{Id: 3, NodeId: "R_gone", Name: "gone", FullName: "octo/gone", Stargazers: 40, LastFetchedFromGithubAt: lastFetched},
+		// Saved before node IDs were
+		{Id: 4, Name: "flaky", FullName: "octo/flaky", Stargazers: 30, LastFetchedFromGithubAt: lastFetched},
+	}
+	if _, err := db.Insert(&repos); err != nil {
+		t.Fatal(err)
+	}
+
+	toRefresh := getReposToRefresh(db, REFRESHER_BATCH_SIZE, nil)
+	resp, err := refreshBatch(server.Client(), toRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"R_found", "R_renamed", "R_gone", legacyNodeId(4)}; !reflect.DeepEqual(requestedIds, expected) {
+		t.Errorf("expected node IDs %v to be requested, got %v", expected, requestedIds)
+	}
+
+	counts := map[string]int{}
+	failed := saveRefreshed(db, toRefresh, resp, counts)
+
+	if !reflect.DeepEqual(failed, []int64{4}) {
+		t.Errorf("expected only the repository without a node or an error to fail, got %v", failed)
+	}
+	if expected := map[string]int{OUTCOME_EXISTS: 1, OUTCOME_MOVED: 1, OUTCOME_DELETED: 1}; !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected outcomes %v, got %v", expected, counts)
+	}
+
+	var saved []Repo
+	if err := db.Asc("Id").Find(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(repos) {
+		t.Fatalf("expected %d repositories, got %d", len(repos), len(saved))
+	}
+
+	found := saved[0]
+	if found.VerificationOutcome != OUTCOME_EXISTS || found.Stargazers != 120 || found.Language != "Go" || found.OpenIssues != 3 || found.Visibility != "public" || found.Owner.Type != "Organization" {
+		t.Errorf("expected the found repository to be refreshed, got %+v", found)
+	}
+	if !found.LastFetchedFromGithubAt.After(lastFetched) {
+		t.Errorf("expected the found repository to be fetched now, got %v", found.LastFetchedFromGithubAt)
+	}
+
+	renamed := saved[1]
+	if renamed.VerificationOutcome != OUTCOME_MOVED || renamed.FullName != "octo/new-name" || renamed.Stargazers != 60 {
+		t.Errorf("expected the renamed repository to be saved under its new name, got %+v", renamed)
+	}
+
+	gone := saved[2]
+	if gone.VerificationOutcome != OUTCOME_DELETED || gone.RemovedAt.IsZero() || gone.VerifiedAt.IsZero() {
+		t.Errorf("expected a tombstone of the repository GitHub couldn't find, got %+v", gone)
+	}
+
+	flaky := saved[3]
+	if flaky.VerificationOutcome != "" || !flaky.RemovedAt.IsZero() || flaky.Stargazers != 30 {
+		t.Errorf("expected the failed repository to be left as it was, got %+v", flaky)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package fetcher
 
 import (
 	"context"
@@ -85,10 +85,18 @@ func createdOnQuery(creation RepoCreationDateRange) string {
 	}
 }
 
+func pushedOnQuery(pushed RepoPushedDateRange, splitByPushed bool) string {
+	if !splitByPushed {
+		return ""
+	}
+	return "pushed:" + pushed.ToQueryString()
+}
+
 func search(githubClient *http.Client, page int, searchTerm ...string) GithubSearchResponse {
 	query := strings.Trim(strings.Join(searchTerm, " "), " ")
 
 	log.Printf("[search] searching with terms '%s' - page %d\n", query, page)
+	searchRequestsMade.Add(1)
 
 	reqUrl := lo.Must(url.Parse("https://api.github.com/search/repositories"))
 
@@ -100,7 +108,7 @@ func search(githubClient *http.Client, page int, searchTerm ...string) GithubSea
 	reqUrl.RawQuery = reqUrlParams.Encode()
 
 	req := lo.Must(http.NewRequest("GET", reqUrl.String(), nil))
-	if *enableRequestLog {
+	if config.EnableRequestLog {
 		reqLogger.Println(string(lo.Must(httputil.DumpRequest(req, false))))
 	}
 
@@ -109,7 +117,7 @@ func search(githubClient *http.Client, page int, searchTerm ...string) GithubSea
 		log.Panicf("Received response code %v from github. Response body: %v", response.Status, string(lo.Must(io.ReadAll(response.Body))))
 	}
 
-	if *enableResponsesLog {
+	if config.EnableResponsesLog {
 		resLogger.Println(string(lo.Must(httputil.DumpResponse(response, false))))
 	}
 
@@ -201,13 +209,73 @@ func nextDateRange(db *xorm.Engine) {
 	newDateRange.Save(db)
 }
 
+// splitByPushedDate starts splitting a slice by the last push date, or halves the pushed date range
+func splitByPushedDate(db *xorm.Engine, pushed RepoPushedDateRange, splitByPushed bool) {
+	if !splitByPushed {
+		log.Println("[pushedDateRange] Too many repositories were created in the same second - splitting them by the last push date")
+	}
+	newPushed := pushed.HalvedRange()
+
+	log.Printf("[pushedDateRange] Halving from %v to %v\n", pushed, newPushed)
+
+	newPushed.Save(db)
+}
+
+func biggerPushedDateRange(db *xorm.Engine) {
+	oldPushed, _ := GetRepoPushedDateRange(db)
+	newPushed := oldPushed.BiggerRange()
+
+	log.Printf("[pushedDateRange] Increasing from %v to %v\n", oldPushed, newPushed)
+
+	newPushed.Save(db)
+}
+
+func nextPushedDateRange(db *xorm.Engine) {
+	oldPushed, _ := GetRepoPushedDateRange(db)
+	newPushed := oldPushed.NextRange()
+
+	log.Printf("[pushedDateRange] Going to the next date from %v to %v\n", oldPushed, newPushed)
+
+	newPushed.Save(db)
+}
+
+// finishSlice moves on once every repository of the current slice was fetched: to the next pushed date range if
+// the slice is split by it, otherwise to the next creation date range or below the current stars
+func finishSlice(db *xorm.Engine, resp GithubSearchResponse, creation RepoCreationDateRange, pushed RepoPushedDateRange, splitByPushed bool) {
+	if splitByPushed && !pushed.CoversToday() {
+		nextPushedDateRange(db)
+		return
+	}
+	if splitByPushed {
+		ResetRepoPushedDateRange(db)
+	}
+
+	if creation.CoversToday() {
+		// this is the last page - we are sure nothing was missed, can decrease to one beyond minimum
+		decreaseMaxStarsBeyondMinimum(db, resp)
+	} else {
+		nextDateRange(db)
+	}
+}
+
 func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 	maxStars, searchWindow := GetMaxStars(db), GetSearchWindow(db)
-	minStars := maxStars - searchWindow
 	creationDateRange := GetRepoCreationDateRange(db)
+	pushedDateRange, splitByPushed := GetRepoPushedDateRange(db)
+	if maxStars == MAX_STARS_DISCOVER {
+		discoverMaxStars(ctx, client, db)
+		return
+	}
+	if !splitByPushed {
+		searchWindow, creationDateRange = applyPredictions(db, maxStars, searchWindow, creationDateRange)
+	}
+	minStars := maxStars - searchWindow
 
 	log.Println("-- Fetcher --")
 	log.Printf("-- Stars: [from %v to %v] -> window=%v, creation date range: %v --\n", minStars, maxStars, searchWindow, creationDateRange.ToQueryString())
+	if splitByPushed {
+		log.Printf("-- Pushed date range: %v --\n", pushedDateRange.ToQueryString())
+	}
 
 	if creationDateRange.howManySeconds < 0 {
 		log.Printf("creationDateRange.howManySeconds got set to %v, resetting to 60\n", creationDateRange.howManySeconds)
@@ -228,16 +296,18 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 		return
 	}
 
-	if maxStars >= MAX_STARS_DEFAULT {
-		log.Println("switching from fetching very big repos to big ones")
-		fetchAndSaveReposWithVeryHighStarsCount(db, client, ctx)
-		SetMaxStars(db, maxStars-1)
+	if minStars < sweepFloor() {
+		// Stars below the floor are another shard's, or not wanted at all
+		log.Printf("Search window reaches below %v stars - capping to %v\n", sweepFloor(), maxStars-sweepFloor())
+		SetSearchWindow(db, maxStars-sweepFloor())
 		return
 	}
 
 	// TODO: handle IncompleteResults == true
 
-	firstPage := search(client, 1, minMaxStarsQuery(minStars, maxStars), createdOnQuery(creationDateRange))
+	searchTerms := []string{minMaxStarsQuery(minStars, maxStars), createdOnQuery(creationDateRange), pushedOnQuery(pushedDateRange, splitByPushed)}
+
+	firstPage := search(client, 1, searchTerms...)
 	lo.Must0(firstPage.WaitIfNeccessary(ctx))
 	save(db, firstPage)
 
@@ -247,25 +317,45 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 	}
 
 	if firstPage.TotalCount > MAX_RESULTS_PER_PAGE*MAX_PAGES {
-		if searchWindow > 0 {
-			// we might be missing some results, redo the same search later with a decreased
-			// window size to get them
-			decreaseStarWindowSize(db)
-		} else if creationDateRange.howManySeconds > 0 {
-			// Search star window is 0, cannot decrease it anymore.
-			// Start decreasing the creation days window
-			halveDateRange(db)
+		// Don't trust predictions for this many stars anymore - the database is out of date
+		predictionOverflowedAt = maxStars
+
+		if searchWindow == 0 && creationDateRange.howManySeconds == 0 && splitByPushed && pushedDateRange.howManySeconds == 0 {
+			// Can't be split any further - this shouldn't ever happen. Fetch what search returns and move on.
+			log.Printf("[pushedDateRange] Cannot make the query any more specific! Fetching the first %d of %d results and skipping the rest\n",
+				MAX_RESULTS_PER_PAGE*MAX_PAGES, firstPage.TotalCount)
 		} else {
-			panic("Cannot make the query any more specific!")
+			if searchWindow > 0 {
+				// we might be missing some results, redo the same search later with a decreased
+				// window size to get them
+				decreaseStarWindowSize(db)
+			} else if creationDateRange.howManySeconds > 0 {
+				// Search star window is 0, cannot decrease it anymore.
+				// Start decreasing the creation days window
+				halveDateRange(db)
+			} else {
+				// Too many repositories created in the same second - split them by when they were last pushed to
+				splitByPushedDate(db, pushedDateRange, splitByPushed)
+			}
+			// Don't request other result pages - something might be missing
+			return
 		}
-		// Don't request other result pages - something might be missing
-		return
 	}
 
-	pages := numberOfPages(firstPage.TotalCount)
+	pages := min(numberOfPages(firstPage.TotalCount), MAX_PAGES)
 
 	// Only do after checking if TotalCount wasn't overflowed
 	if pages == 0 {
+		if splitByPushed && !pushedDateRange.CoversToday() {
+			log.Println("[zero] No results are present, the pushed date range doesn't cover today - going to the next pushed date range and increasing its size")
+			nextPushedDateRange(db)
+			biggerPushedDateRange(db)
+			return
+		}
+		if splitByPushed {
+			ResetRepoPushedDateRange(db)
+		}
+
 		if creationDateRange.CoversToday() {
 			log.Println("[zero] No results are present, the date range covers today - decreasing maxStars by 1 and increasing the window size")
 			SetMaxStars(db, maxStars-1)
@@ -280,18 +370,19 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 	}
 
 	if !firstPage.IncompleteResults && pages == 1 {
-		// this is the only page - we are sure nothing was missed, can decrease to one beyond minimum
-		if creationDateRange.CoversToday() {
-			decreaseMaxStarsBeyondMinimum(db, firstPage)
-		} else {
-			nextDateRange(db)
-		}
+		// this is the only page - we are sure nothing was missed
+		finishSlice(db, firstPage, creationDateRange, pushedDateRange, splitByPushed)
 	} else {
 		// There are still results left to fetch for this amount of stars
 		decreaseMaxStarsToMinumum(db, firstPage)
 	}
 
-	if firstPage.TotalCount > MAX_RESULTS_PER_PAGE*(MAX_PAGES-2) && searchWindow != 0 {
+	if splitByPushed {
+		if firstPage.TotalCount <= MAX_RESULTS_PER_PAGE*4 && !pushedDateRange.CoversToday() {
+			// the star window and the creation date range have to stay as they are - make the pushed date range bigger
+			biggerPushedDateRange(db)
+		}
+	} else if firstPage.TotalCount > MAX_RESULTS_PER_PAGE*(MAX_PAGES-2) && searchWindow != 0 {
 		// we got pretty close to the limit - but no repositories should be missing due to
 		// the result fitting in the 1000 responses limit
 		decreaseStarWindowSize(db)
@@ -318,7 +409,7 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 		firstBatchSize := min(firstPage.RatelimitRemaining, pagesLeftToProcess)
 		maybeResponsesBeforeRatelimit := make(chan mo.Either[GithubSearchResponse, GithubSearchResponseError], firstBatchSize)
 		for i := 0; i < firstBatchSize; i++ {
-			go searchToChannel(client, maybeResponsesBeforeRatelimit, startFetchAtPage+i, minMaxStarsQuery(minStars, maxStars), createdOnQuery(creationDateRange))
+			go searchToChannel(client, maybeResponsesBeforeRatelimit, startFetchAtPage+i, searchTerms...)
 		}
 		for i := 0; i < firstBatchSize; i++ {
 			maybeResponse, ok := <-maybeResponsesBeforeRatelimit
@@ -333,7 +424,7 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 
 	// And now either fetch all the pages or (if we were low on Ratelimit) fetch the pages left
 	for i := startFetchAtPage; i <= pages; i++ {
-		go searchToChannel(client, maybeResponses, i, minMaxStarsQuery(minStars, maxStars), createdOnQuery(creationDateRange))
+		go searchToChannel(client, maybeResponses, i, searchTerms...)
 	}
 
 	savedMaybeResponses := make([]mo.Result[GithubSearchResponse], pagesLeftToProcess)
@@ -357,12 +448,8 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 		// for all the previous ones
 		response := savedMaybeResponse.MustGet()
 		if !response.IncompleteResults && response.Page == pages {
-			if creationDateRange.CoversToday() {
-				// this is the last page - we are sure nothing was missed, can decrease to one beyond minimum
-				decreaseMaxStarsBeyondMinimum(db, response)
-			} else {
-				nextDateRange(db)
-			}
+			// this is the last page - we are sure nothing was missed
+			finishSlice(db, response, creationDateRange, pushedDateRange, splitByPushed)
 		} else {
 			decreaseMaxStarsToMinumum(db, response)
 		}
@@ -370,28 +457,63 @@ func doFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) {
 }
 
 func save(db *xorm.Engine, resp GithubSearchResponse) {
+	if len(resp.Items) == 0 {
+		return
+	}
+
 	lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
-		for _, repo := range resp.Items {
+		cycle := GetCycle(tx)
+		repos := make([]Repo, len(resp.Items))
+		savedIds := map[string]int64{}
+		for i, repo := range resp.Items {
 			repo.LastFetchedFromGithubAt = time.Now()
-			repo.NotSeenSinceCounter = 0
-			if lo.Must(tx.Exist(&Repo{Id: repo.Id})) {
-				lo.Must(tx.ID(repo.Id).AllCols().Update(repo))
-			} else {
-				repo.FirstFetchedFromGithubAt = time.Now()
-				lo.Must(tx.Insert(repo))
+			repo.FirstFetchedFromGithubAt = time.Now()
+			repo.LastSeenCycle = cycle
+			repos[i] = repo
+			savedIds[repo.FullName] = repo.Id
+		}
+
+		var existing []Repo
+		lo.Must0(tx.In("Id", lo.ToAnySlice(lo.Map(repos, func(repo Repo, _ int) int64 { return repo.Id }))...).
+			Cols("Id", "FullName").
+			Find(&existing))
+		previousNames := lo.SliceToMap(existing, func(repo Repo) (int64, string) { return repo.Id, repo.FullName })
+		for _, repo := range repos {
+			if previousName := previousNames[repo.Id]; previousName != "" && previousName != repo.FullName {
+				recordRename(tx, repo.Id, previousName, repo.FullName)
 			}
+		}
 
-			// Get rid of repositories with a different ID than just inserted, but with the same FullName
-			// This happens when a repository is deleted, but a new one with the same name is created in its place
-			affected := lo.Must(tx.Where("Id != ? and FullName = ?", repo.Id, repo.FullName).Unscoped().Delete(&Repo{}))
-			if affected > 0 {
-				log.Printf("[save] Deleted %d duplicate entries for repo %s", affected, repo.FullName)
+		upsertRepos(db, tx, repos)
+
+		// Tombstone repositories with a different ID than just saved, but with the same FullName
+		// This happens when a repository is deleted, but a new one with the same name is created in its place
+		var sameNames []Repo
+		lo.Must0(tx.In("FullName", lo.ToAnySlice(lo.Keys(savedIds))...).
+			And("coalesce(VerificationOutcome, '') != ?", OUTCOME_REPLACED).
+			Cols("Id", "FullName").
+			Find(&sameNames))
+		for _, replacedRepo := range sameNames {
+			if replacedRepo.Id != savedIds[replacedRepo.FullName] {
+				tombstone(tx, replacedRepo.Id, OUTCOME_REPLACED)
+				log.Printf("[save] Marked repo %d as replaced by %d, which is called %s now", replacedRepo.Id, savedIds[replacedRepo.FullName], replacedRepo.FullName)
 			}
 		}
 		return nil, nil
 	}))
 }
 
+// recordRename remembers a repository's previous name, so links to it can be redirected
+func recordRename(tx *xorm.Session, repoId int64, oldFullName string, newFullName string) {
+	log.Printf("[save] Repo %d was renamed or transferred from %s to %s\n", repoId, oldFullName, newFullName)
+	lo.Must(tx.Insert(&RepoNameHistory{
+		RepoId:      repoId,
+		OldFullName: oldFullName,
+		NewFullName: newFullName,
+		ChangedAt:   time.Now(),
+	}))
+}
+
 func decreaseMaxStarsToMinumum(db *xorm.Engine, resp GithubSearchResponse) {
 	lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
 		if len(resp.Items) > 0 {
@@ -428,23 +550,21 @@ func numberOfPages(results int64) int {
 	return int(pages)
 }
 
-func fetchAndSaveReposWithVeryHighStarsCount(db *xorm.Engine, client *http.Client, ctx context.Context) {
-	log.Printf("Fetching and saving the top of the top - repositiories with at least %d stars\n", MAX_STARS_DEFAULT)
-	minStars := int64(MAX_STARS_DEFAULT)
-
-	resp := search(client, 1, minStarsQuery(minStars))
+// discoverMaxStars starts a sweep at the most starred repository on GitHub, instead of a fixed ceiling which
+// GitHub could outgrow. Everything below is then crawled by star windows, like the rest of the sweep.
+func discoverMaxStars(ctx context.Context, client *http.Client, db *xorm.Engine) {
+	resp := search(client, 1, minStarsQuery(sweepFloor()-1))
 	lo.Must0(resp.WaitIfNeccessary(ctx))
 	save(db, resp)
 
-	pages := numberOfPages(resp.TotalCount)
-
-	if pages > MAX_PAGES {
-		panic("There's too many 'top of the top' repositories - increase the limit!")
+	if len(resp.Items) == 0 {
+		log.Printf("No repositories with at least %d stars - nothing to fetch\n", sweepFloor())
+		SetMaxStars(db, sweepFloor()-1)
+		return
 	}
 
-	for page := 2; page <= pages; page += 1 {
-		res := search(client, page, minStarsQuery(minStars))
-		lo.Must0(res.WaitIfNeccessary(ctx))
-		save(db, res)
-	}
+	mostStars := resp.Items[0].Stargazers
+	maxStars := mostStars + mostStars/MAX_STARS_HEADROOM_FRACTION + 1
+	log.Printf("The most starred repository, %s, has %d stars - starting the sweep at %d\n", resp.Items[0].FullName, mostStars, maxStars)
+	SetMaxStars(db, maxStars)
 }
@@ -0,0 +1,315 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+const (
+	// Which sweep shards are currently planned for - every sweep splits the star space anew
+	SWEEP_KEY = "sweep"
+	// Where shards are split if the database doesn't have repositories to split them by yet
+	SHARDS_FALLBACK_MAX_STARS = 100000
+	// How many times the lease on a shard is renewed in config.LeaseDuration - a renewal can fail, or come late
+	LEASE_RENEWALS = 3
+)
+
+// Shard is a range of stars one worker crawls in a sweep, leased to it in the database so that other workers
+// crawl other shards. Leases of crashed workers expire, and their shards are picked up by others.
+type Shard struct {
+	Id       int64 `xorm:"pk autoincr"`
+	Sweep    int64 `xorm:"notnull index"`
+	MinStars int64 `xorm:"notnull"`
+	// MAX_STARS_DISCOVER for the top shard - it starts at the most starred repository
+	MaxStars       int64 `xorm:"notnull"`
+	LeaseOwner     string
+	LeaseExpiresAt time.Time
+	DoneAt         time.Time
+}
+
+// The shard this process is crawling - nil if the sweep isn't sharded. The search cursor in the state is
+// scoped to it.
+var currentShard *Shard
+
+var errLeaseLost = errors.New("the lease on the shard was lost")
+
+// cursorKey scopes a key of the search cursor to the current shard
+func cursorKey(key string) string {
+	if currentShard == nil {
+		return key
+	}
+	return fmt.Sprintf("shard-%d:%s", currentShard.Id, key)
+}
+
+// workerKey scopes a key to this worker - every worker has its own credentials, and so its own rate limits
+func workerKey(key string) string {
+	if config.Shards <= 1 {
+		return key
+	}
+	return fmt.Sprintf("worker-%s:%s", config.WorkerId, key)
+}
+
+// sweepFloor returns the fewest stars the current search cursor goes down to
+func sweepFloor() int64 {
+	if currentShard == nil {
+		return config.MinimumStars
+	}
+	return currentShard.MinStars
+}
+
+// inCurrentShard limits a query to repositories with stars in the current shard, so that workers don't
+// refresh, verify or enrich the same repositories. Repositories which fell below the minimum stars belong
+// to the bottom shard.
+func inCurrentShard(session *xorm.Session, starsColumn string) *xorm.Session {
+	if currentShard == nil {
+		return session
+	}
+	session = session.And(starsColumn+" <= ?", currentShard.MaxStars)
+	if currentShard.MinStars > config.MinimumStars {
+		session = session.And(starsColumn+" >= ?", currentShard.MinStars)
+	}
+	return session
+}
+
+// shardBoundaries returns the fewest stars of every shard but the bottom one, from the top. Shards get about as
+// many repositories each as the previous sweep found, or - without any - have geometrically growing star ranges.
+func shardBoundaries(tx *xorm.Session, shards int) []int64 {
+	total := lo.Must(notGone(tx.Where("Stargazers >= ?", config.MinimumStars)).Count(&Repo{}))
+
+	var boundaries []int64
+	for i := 1; i < shards; i++ {
+		var boundary int64
+		if total >= int64(shards) {
+			var stargazers []int64
+			lo.Must0(notGone(tx.Table("Repo").Where("Stargazers >= ?", config.MinimumStars)).
+				Desc("Stargazers").
+				Limit(1, int(total*int64(i)/int64(shards))).
+				Cols("Stargazers").
+				Find(&stargazers))
+			boundary = stargazers[0]
+		} else {
+			ratio := float64(SHARDS_FALLBACK_MAX_STARS) / float64(max(config.MinimumStars, 1))
+			boundary = int64(float64(max(config.MinimumStars, 1)) * math.Pow(ratio, float64(shards-i)/float64(shards)))
+		}
+
+		// Many repositories can have the same stars - shards can't share them
+		if boundary > config.MinimumStars && (len(boundaries) == 0 || boundary < boundaries[len(boundaries)-1]) {
+			boundaries = append(boundaries, boundary)
+		}
+	}
+	return boundaries
+}
+
+// planShards splits the star space of a new sweep into config.Shards shards
+func planShards(tx *xorm.Session, sweep int64) {
+	maxStars := int64(MAX_STARS_DISCOVER)
+	for _, boundary := range shardBoundaries(tx, config.Shards) {
+		lo.Must(tx.Insert(&Shard{Sweep: sweep, MinStars: boundary, MaxStars: maxStars}))
+		maxStars = boundary - 1
+	}
+	lo.Must(tx.Insert(&Shard{Sweep: sweep, MinStars: config.MinimumStars, MaxStars: maxStars}))
+}
+
+// startSweep returns the sweep in progress, or plans the next one if every shard of the last one is done
+func startSweep(db *xorm.Engine) int64 {
+	return lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
+		sweep := getFromState[int64](tx, SWEEP_KEY, 0)
+		planned := lo.Must(tx.Where("Sweep = ?", sweep).Count(&Shard{}))
+		left := lo.Must(tx.Where("Sweep = ?", sweep).And("DoneAt IS NULL").Count(&Shard{}))
+		if planned > 0 && left > 0 {
+			return sweep, nil
+		}
+
+		sweep++
+		planShards(tx, sweep)
+		setToState(tx, SWEEP_KEY, sweep)
+		log.Printf("[shards] Planned sweep %d in %d shards\n", sweep, lo.Must(tx.Where("Sweep = ?", sweep).Count(&Shard{})))
+		return sweep, nil
+	})).(int64)
+}
+
+// claimShard leases a shard of the sweep nobody else is crawling: the one this worker held before a restart,
+// one never leased, or one whose lease expired. Returns false if there's none.
+func claimShard(db *xorm.Engine, sweep int64) (Shard, bool) {
+	claimed := lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
+		var shards []Shard
+		lo.Must0(tx.Where("Sweep = ?", sweep).
+			And("DoneAt IS NULL").
+			And("(coalesce(LeaseOwner, '') IN ('', ?) OR LeaseExpiresAt < ?)", config.WorkerId, time.Now().Format("2006-01-02 15:04:05")).
+			OrderBy("coalesce(LeaseOwner, '') = ? DESC, Id ASC", config.WorkerId).
+			Limit(1).
+			Find(&shards))
+		if len(shards) == 0 {
+			return nil, nil
+		}
+
+		shard := shards[0]
+		if shard.LeaseOwner != "" && shard.LeaseOwner != config.WorkerId {
+			log.Printf("[shards] The lease of %s on shard %d expired at %v - taking it over\n", shard.LeaseOwner, shard.Id, shard.LeaseExpiresAt)
+		}
+		shard.LeaseOwner = config.WorkerId
+		shard.LeaseExpiresAt = time.Now().Add(config.LeaseDuration)
+		lo.Must(tx.ID(shard.Id).Cols("LeaseOwner", "LeaseExpiresAt").Update(&shard))
+		return &shard, nil
+	}))
+	if claimed == nil {
+		return Shard{}, false
+	}
+	return *claimed.(*Shard), true
+}
+
+// leaseHeld is the condition on the shard being leased to this worker, with the lease not expired yet
+func leaseHeld(shardId int64) (condition string, args []any) {
+	return "Id = ? AND LeaseOwner = ? AND LeaseExpiresAt > ?", []any{shardId, config.WorkerId, time.Now().Format("2006-01-02 15:04:05")}
+}
+
+// renewLease extends the lease on the shard, returning false if another worker took it over in the meantime
+func renewLease(db *xorm.Engine, shard Shard) (bool, error) {
+	renewed, err := db.ID(shard.Id).
+		Where("LeaseOwner = ?", config.WorkerId).
+		Cols("LeaseExpiresAt").
+		Update(&Shard{LeaseExpiresAt: time.Now().Add(config.LeaseDuration)})
+	return renewed == 1, err
+}
+
+// keepLease renews the lease on the shard LEASE_RENEWALS times per config.LeaseDuration until ctx is done - or
+// cancels ctx with errLeaseLost once another worker took the shard over
+func keepLease(ctx context.Context, stop context.CancelCauseFunc, db *xorm.Engine, shard Shard) {
+	ticker := time.NewTicker(config.LeaseDuration / LEASE_RENEWALS)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := renewLease(db, shard)
+			if err != nil {
+				// Tried again with the next tick - the lease lasts for a few of them
+				log.Printf("[shards] Could not renew the lease on shard %d: %v\n", shard.Id, err)
+				continue
+			}
+			if !renewed {
+				stop(errLeaseLost)
+				return
+			}
+		}
+	}
+}
+
+// finishShard marks the shard as done, if this worker still holds the lease on it - returns errLeaseLost
+// otherwise. Whoever finishes the last shard of the sweep ends the sweep.
+func finishShard(ctx context.Context, db *xorm.Engine, shard Shard) error {
+	condition, args := leaseHeld(shard.Id)
+	finished := lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
+		if lo.Must(tx.Where(condition, args...).Cols("DoneAt").Update(&Shard{DoneAt: time.Now()})) == 0 {
+			return nil, nil
+		}
+		lo.Must(tx.Exec("DELETE FROM State WHERE Name LIKE ?", fmt.Sprintf("shard-%d:%%", shard.Id)))
+		left := lo.Must(tx.Where("Sweep = ?", shard.Sweep).And("DoneAt IS NULL").Count(&Shard{}))
+		return left == 0, nil
+	}))
+	if finished == nil {
+		return errLeaseLost
+	}
+	sweepDone := finished.(bool)
+
+	log.Printf("[shards] Finished shard %d (%d..%d stars)\n", shard.Id, shard.MinStars, shard.MaxStars)
+	if sweepDone {
+		log.Printf("[shards] Finished the last shard of sweep %d - ending all work.\n", shard.Sweep)
+		SetMinimumStars(db, config.MinimumStars)
+		reportPredictions(db)
+		startNextCycle(db)
+	}
+	return nil
+}
+
+// shardedFetcherTask crawls shards of the sweep until there are none left to claim
+func shardedFetcherTask(ctx context.Context, client *http.Client, db *xorm.Engine) error {
+	sweep := startSweep(db)
+	for {
+		shard, ok := claimShard(db, sweep)
+		if !ok {
+			log.Printf("[shards] No shards of sweep %d left to claim\n", sweep)
+			return nil
+		}
+
+		log.Printf("[shards] Crawling shard %d (%d..%d stars) as %s\n", shard.Id, shard.MinStars, shard.MaxStars, config.WorkerId)
+		currentShard = &shard
+		if GetMaxStars(db) == MAX_STARS_DISCOVER && shard.MaxStars != MAX_STARS_DISCOVER {
+			SetMaxStars(db, shard.MaxStars)
+		}
+
+		crawlCtx, stopCrawl := context.WithCancelCause(ctx)
+		leaseKept := make(chan struct{})
+		go func() {
+			keepLease(crawlCtx, stopCrawl, db, shard)
+			close(leaseKept)
+		}()
+
+		err := crawl(crawlCtx, client, db)
+		currentShard = nil
+		if err == nil {
+			err = finishShard(ctx, db, shard)
+		}
+		stopCrawl(nil)
+		<-leaseKept
+
+		if errors.Is(err, errLeaseLost) {
+			log.Printf("[shards] Another worker took over shard %d - claiming another one\n", shard.Id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ShardStatus is the state of a shard of the current sweep, as shown by the status command
+type ShardStatus struct {
+	Shard
+	// Where the shard's search cursor is - stars below it are done
+	CursorMaxStars int64
+	// One of "done", "leased", "expired" or "free"
+	State string
+}
+
+// Status returns the shards of the current sweep, or nothing if the sweep isn't sharded
+func Status(cfg Config) (sweep int64, shards []ShardStatus) {
+	config = cfg
+	db := initialiseDb()
+	defer db.Close()
+
+	sweep = getFromState[int64](db, SWEEP_KEY, 0)
+	var rows []Shard
+	lo.Must0(db.Where("Sweep = ?", sweep).Asc("Id").Find(&rows))
+
+	for _, row := range rows {
+		status := ShardStatus{Shard: row}
+		switch {
+		case !row.DoneAt.IsZero():
+			status.State = "done"
+		case row.LeaseOwner == "":
+			status.State = "free"
+		case row.LeaseExpiresAt.Before(time.Now()):
+			status.State = "expired"
+		default:
+			status.State = "leased"
+		}
+
+		currentShard = &row
+		status.CursorMaxStars = GetMaxStars(db)
+		currentShard = nil
+
+		shards = append(shards, status)
+	}
+	return sweep, shards
+}
@@ -0,0 +1,131 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// leasedShard sets up a shard leased to the given worker until leaseExpiresAt, crawled by this worker
+func leasedShard(t *testing.T, db *xorm.Engine, owner string, leaseExpiresAt time.Time) Shard {
+	t.Helper()
+	shard := Shard{Sweep: 1, MinStars: 10, MaxStars: 100, LeaseOwner: owner, LeaseExpiresAt: leaseExpiresAt}
+	if _, err := db.Insert(&shard); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := currentShard
+	t.Cleanup(func() { currentShard = previous })
+	currentShard = &shard
+	return shard
+}
+
+func useTestWorker(t *testing.T) {
+	useTestDb(t)
+	config.Shards = 2
+	config.WorkerId = "worker-a"
+}
+
+// recoverLeaseLost runs f, returning whether it panicked with errLeaseLost
+func recoverLeaseLost(t *testing.T, f func()) (lost bool) {
+	t.Helper()
+	defer func() {
+		if caught := recover(); caught != nil {
+			err, ok := caught.(error)
+			if !ok || !errors.Is(err, errLeaseLost) {
+				t.Fatalf("expected a panic with errLeaseLost, got %v", caught)
+			}
+			lost = true
+		}
+	}()
+	f()
+	return false
+}
+
+func TestCursorIsOnlyMovedWithTheLease(t *testing.T) {
+	useTestWorker(t)
+	db := initialiseDb()
+	defer db.Close()
+
+	shard := leasedShard(t, db, config.WorkerId, time.Now().Add(time.Hour))
+	if recoverLeaseLost(t, func() { SetSearchWindow(db, 50) }) {
+		t.Fatalf("expected the cursor to move while the lease is held")
+	}
+	if window := GetSearchWindow(db); window != 50 {
+		t.Errorf("expected the search window to be saved, got %d", window)
+	}
+
+	for name, lease := range map[string]Shard{
+		"expired":    {LeaseOwner: config.WorkerId, LeaseExpiresAt: time.Now().Add(-time.Minute)},
+		"taken over": {LeaseOwner: "worker-b", LeaseExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if _, err := db.ID(shard.Id).Cols("LeaseOwner", "LeaseExpiresAt").Update(&lease); err != nil {
+			t.Fatal(err)
+		}
+		if !recoverLeaseLost(t, func() { SetSearchWindow(db, 20) }) {
+			t.Errorf("%s: expected moving the cursor to fail with errLeaseLost", name)
+		}
+		if window := GetSearchWindow(db); window != 50 {
+			t.Errorf("%s: expected the search window to stay at 50, got %d", name, window)
+		}
+	}
+}
+
+func TestFinishShardNeedsTheLease(t *testing.T) {
+	useTestWorker(t)
+	db := initialiseDb()
+	defer db.Close()
+
+	// Taken over by worker-b, which moved the cursor since
+	shard := leasedShard(t, db, "worker-b", time.Now().Add(time.Hour))
+	config.WorkerId = "worker-b"
+	SetSearchWindow(db, 50)
+	config.WorkerId = "worker-a"
+	currentShard = nil
+
+	if err := finishShard(context.Background(), db, shard); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("expected finishing a shard leased to another worker to fail with errLeaseLost, got %v", err)
+	}
+	if done := queryInt(t, db, "select count(*) from Shard where DoneAt is not null"); done != 0 {
+		t.Errorf("expected the shard not to be done")
+	}
+	if cursor := queryInt(t, db, "select count(*) from State where Name like 'shard-%'"); cursor != 1 {
+		t.Errorf("expected the other worker's cursor to be kept, got %d keys", cursor)
+	}
+}
+
+func TestKeepLease(t *testing.T) {
+	useTestWorker(t)
+	config.LeaseDuration = 30 * time.Millisecond
+	db := initialiseDb()
+	defer db.Close()
+
+	shard := leasedShard(t, db, config.WorkerId, time.Now().Add(-time.Hour))
+	ctx, stop := context.WithCancelCause(context.Background())
+	leaseKept := make(chan struct{})
+	go func() {
+		keepLease(ctx, stop, db, shard)
+		close(leaseKept)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for queryInt(t, db, "select count(*) from Shard where LeaseExpiresAt > ?", time.Now().Add(-time.Minute).Format("2006-01-02 15:04:05")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the lease to be renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mustExec(t, db, "update Shard set LeaseOwner = 'worker-b'")
+	select {
+	case <-leaseKept:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected keeping the lease to stop once another worker took the shard over")
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, errLeaseLost) {
+		t.Errorf("expected the crawl to be stopped with errLeaseLost, got %v", cause)
+	}
+}
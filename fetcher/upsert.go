@@ -0,0 +1,103 @@
+package fetcher
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+	"xorm.io/xorm/dialects"
+	"xorm.io/xorm/schemas"
+)
+
+// Columns kept as they are when a repository which is already in the database is saved again
+var upsertKeptColumns = []string{"Id", "FirstFetchedFromGithubAt"}
+
+type upsertStatementKey struct {
+	db   *xorm.Engine
+	rows int
+}
+
+// Prepared upserts of repositories, by the engine and how many rows they insert - pages of search results almost
+// always have the same number of repositories, so there's only a few of them. Closed with the engine.
+var (
+	upsertStatements      = map[upsertStatementKey]*sql.Stmt{}
+	upsertStatementsMutex sync.Mutex
+)
+
+// repoColumns returns the columns of Repo, in the order of its fields
+func repoColumns(db *xorm.Engine) []*schemas.Column {
+	return lo.Must(db.TableInfo(&Repo{})).Columns()
+}
+
+// upsertSql builds a statement inserting rows repositories at once, or updating them if they're already there
+func upsertSql(db *xorm.Engine, rows int) string {
+	columns := lo.Map(repoColumns(db), func(column *schemas.Column, _ int) string { return column.Name })
+
+	placeholders := "(?" + strings.Repeat(", ?", len(columns)-1) + ")"
+	updates := lo.FilterMap(columns, func(column string, _ int) (string, bool) {
+		return fmt.Sprintf("`%s` = excluded.`%s`", column, column), !lo.Contains(upsertKeptColumns, column)
+	})
+
+	return fmt.Sprintf("INSERT INTO `Repo` (`%s`) VALUES %s ON CONFLICT (`Id`) DO UPDATE SET %s",
+		strings.Join(columns, "`, `"),
+		placeholders+strings.Repeat(", "+placeholders, rows-1),
+		strings.Join(updates, ", "))
+}
+
+// upsertStatement returns the statement upserting rows repositories, preparing it the first time it's needed
+func upsertStatement(db *xorm.Engine, rows int) *sql.Stmt {
+	upsertStatementsMutex.Lock()
+	defer upsertStatementsMutex.Unlock()
+
+	key := upsertStatementKey{db, rows}
+	if _, ok := upsertStatements[key]; !ok {
+		upsertStatements[key] = lo.Must(db.DB().DB.Prepare(upsertSql(db, rows)))
+	}
+	return upsertStatements[key]
+}
+
+// closeUpsertStatements closes the statements prepared for the engine, before it's closed itself
+func closeUpsertStatements(db *xorm.Engine) {
+	upsertStatementsMutex.Lock()
+	defer upsertStatementsMutex.Unlock()
+
+	for key, statement := range upsertStatements {
+		if key.db == db {
+			lo.Must0(statement.Close())
+			delete(upsertStatements, key)
+		}
+	}
+}
+
+// columnValue converts a field of the repository to what xorm would store in the column
+func columnValue(db *xorm.Engine, column *schemas.Column, repo *Repo) any {
+	field := lo.Must(column.ValueOf(repo))
+	switch value := field.Interface().(type) {
+	case time.Time:
+		return lo.Must(dialects.FormatColumnTime(db.Dialect(), db.DatabaseTZ, column, value))
+	case []string:
+		return string(lo.Must(json.Marshal(value)))
+	default:
+		return value
+	}
+}
+
+// upsertRepos inserts repositories - updating every column but upsertKeptColumns of ones already in the
+// database - with one prepared statement per MAX_RESULTS_PER_PAGE of them
+func upsertRepos(db *xorm.Engine, tx *xorm.Session, repos []Repo) {
+	columns := repoColumns(db)
+	for _, batch := range lo.Chunk(repos, MAX_RESULTS_PER_PAGE) {
+		args := make([]any, 0, len(batch)*len(columns))
+		for i := range batch {
+			for _, column := range columns {
+				args = append(args, columnValue(db, column, &batch[i]))
+			}
+		}
+		lo.Must(tx.Tx().Tx.Stmt(upsertStatement(db, len(batch))).Exec(args...))
+	}
+}
@@ -0,0 +1,107 @@
+package fetcher
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"xorm.io/xorm"
+)
+
+// Repositories in the database before pages are saved in BenchmarkSave - writes get slower as the table and its
+// indexes grow
+const benchmarkSeededRepos = 100000
+
+// benchmarkRepo makes up a repository the way search would return it
+func benchmarkRepo(id int64, sweep int) Repo {
+	repo := Repo{
+		Id:            id,
+		NodeId:        legacyNodeId(id),
+		Name:          fmt.Sprintf("repo-%d", id),
+		FullName:      fmt.Sprintf("owner-%d/repo-%d", id%1000, id),
+		GithubLink:    fmt.Sprintf("https://github.com/owner-%d/repo-%d", id%1000, id),
+		Description:   "A repository made up for benchmarking",
+		Language:      "Go",
+		Stargazers:    id%10000 + int64(sweep),
+		Topics:        []string{"benchmark", "sqlite"},
+		DefaultBranch: "main",
+		Visibility:    "public",
+		CreatedAt:     githubCreationDay().Add(time.Duration(id) * time.Minute),
+		RepoPushedAt:  time.Now(),
+		RepoUpdatedAt: time.Now(),
+	}
+	repo.Owner.Login = fmt.Sprintf("owner-%d", id%1000)
+	repo.Owner.Type = "User"
+	return repo
+}
+
+// seedBenchmarkDb fills the database with seededRepos repositories, in batches of 100
+func seedBenchmarkDb(db *xorm.Engine, seededRepos int64) {
+	for start := int64(1); start <= seededRepos; start += MAX_RESULTS_PER_PAGE {
+		batch := []Repo{}
+		for id := start; id < start+MAX_RESULTS_PER_PAGE && id <= seededRepos; id++ {
+			repo := benchmarkRepo(id, 0)
+			repo.FirstFetchedFromGithubAt = time.Now()
+			repo.LastFetchedFromGithubAt = time.Now()
+			batch = append(batch, repo)
+		}
+		lo.Must(db.Insert(&batch))
+	}
+}
+
+// benchmarkPage is a page of search results with as many repositories seen before as new ones, some of the
+// seen ones renamed
+func benchmarkPage(page int) GithubSearchResponse {
+	var resp GithubSearchResponse
+	for i := int64(0); i < MAX_RESULTS_PER_PAGE; i++ {
+		var repo Repo
+		if i%2 == 0 {
+			repo = benchmarkRepo(1+(int64(page)*MAX_RESULTS_PER_PAGE+i)%benchmarkSeededRepos, 1)
+			if i%10 == 0 {
+				repo.FullName += fmt.Sprintf("-renamed-%d", page)
+			}
+		} else {
+			repo = benchmarkRepo(benchmarkSeededRepos+int64(page)*MAX_RESULTS_PER_PAGE+i, 1)
+		}
+		resp.Items = append(resp.Items, repo)
+	}
+	return resp
+}
+
+func TestCloseUpsertStatements(t *testing.T) {
+	useTestDb(t)
+	db := initialiseDb()
+	defer db.Close()
+
+	save(db, GithubSearchResponse{Items: []Repo{{Id: 1, FullName: "o/r", Stargazers: 10}}})
+	if _, ok := upsertStatements[upsertStatementKey{db, 1}]; !ok {
+		t.Fatalf("expected the upsert of one repository to be prepared")
+	}
+
+	closeUpsertStatements(db)
+	for key := range upsertStatements {
+		if key.db == db {
+			t.Errorf("expected statements of the closed engine to be forgotten, found one for %d rows", key.rows)
+		}
+	}
+}
+
+// BenchmarkSave times saving a page of search results into a seeded database
+func BenchmarkSave(b *testing.B) {
+	useTestDb(b)
+	db := initialiseDb()
+	defer db.Close()
+	defer closeUpsertStatements(db)
+	seedBenchmarkDb(db, benchmarkSeededRepos)
+
+	pages := make([]GithubSearchResponse, b.N)
+	for i := range pages {
+		pages[i] = benchmarkPage(i)
+	}
+
+	b.ResetTimer()
+	for _, page := range pages {
+		save(db, page)
+	}
+}
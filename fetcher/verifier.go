@@ -0,0 +1,311 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/samber/lo/parallel"
+	"xorm.io/xorm"
+)
+
+const (
+	// How many repositories to check at once
+	VERIFIER_PARALLELISM = 25
+	// Requests of the core API quota left for everything else
+	VERIFIER_RATELIMIT_RESERVE = 100
+	// How long a single round of verifying can take, so searching isn't held up for too long
+	VERIFIER_TIME_BUDGET = 2 * time.Minute
+	// Repos not found by more than this many sweeps in a row get verified
+	VERIFIER_MIN_NOT_SEEN_CYCLES = 2
+)
+
+// Outcomes of verifying a repository, saved in Repo.VerificationOutcome
+const (
+	OUTCOME_EXISTS       = "exists"
+	OUTCOME_MOVED        = "moved"
+	OUTCOME_DELETED      = "deleted"
+	OUTCOME_DMCA         = "dmca"
+	OUTCOME_TOS_DISABLED = "tos-disabled"
+	// Not from the verifier: a different repository showed up in search under the same name
+	OUTCOME_REPLACED = "replaced"
+)
+
+// GONE_OUTCOMES are outcomes of repositories which can't be seen on GitHub anymore
+var GONE_OUTCOMES = []string{OUTCOME_DELETED, OUTCOME_DMCA, OUTCOME_TOS_DISABLED, OUTCOME_REPLACED}
+
+// notGone limits a query to repositories which haven't got one of GONE_OUTCOMES. Pass the table to qualify
+// the column with in queries joining other tables.
+func notGone(session *xorm.Session, table ...string) *xorm.Session {
+	column := "VerificationOutcome"
+	if len(table) > 0 {
+		column = table[0] + "." + column
+	}
+	return session.And("coalesce("+column+", '') NOT IN (?"+strings.Repeat(", ?", len(GONE_OUTCOMES)-1)+")", lo.ToAnySlice(GONE_OUTCOMES)...)
+}
+
+// Ratelimit is the core API quota, as reported by a single response
+type Ratelimit struct {
+	Remaining int
+	Reset     time.Time
+	Known     bool
+}
+
+func ratelimitFromResponse(response *http.Response) (ratelimit Ratelimit) {
+	remaining, remainingErr := strconv.Atoi(response.Header.Get("X-Ratelimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(response.Header.Get("X-Ratelimit-Reset"), 10, 64)
+	if remainingErr != nil || resetErr != nil {
+		log.Printf("Could not read the rate limit headers: %v, %v\n", remainingErr, resetErr)
+		return ratelimit
+	}
+
+	return Ratelimit{Remaining: remaining, Reset: time.Unix(reset, 0), Known: true}
+}
+
+// Verification is the result of checking a single repository with the GitHub API
+type Verification struct {
+	Repo Repo
+	// One of OUTCOME_* - or empty, if the check failed and the repository's state is unknown
+	Outcome     string
+	NotModified bool
+	Ratelimit   Ratelimit
+}
+
+func notModified(response *http.Response) bool {
+	return response.StatusCode == http.StatusNotModified
+}
+func surelyExists(response *http.Response) bool {
+	return response.StatusCode == http.StatusOK
+}
+
+// githubErrorResponse is what GitHub responds with for repositories it doesn't serve
+type githubErrorResponse struct {
+	Message string `json:"message"`
+	Block   *struct {
+		Reason string `json:"reason"`
+	} `json:"block"`
+}
+
+// goneOutcome tells why a repository can't be fetched, or returns "" if it's not sure the repository is gone -
+// a 403 is also what exceeding the rate limit looks like
+func goneOutcome(response *http.Response, body []byte) string {
+	var decoded githubErrorResponse
+	_ = json.Unmarshal(body, &decoded)
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return OUTCOME_DELETED
+	case response.StatusCode == http.StatusUnavailableForLegalReasons:
+		// "This repository is currently disabled due to a DMCA takedown notice."
+		return OUTCOME_DMCA
+	case response.StatusCode == http.StatusForbidden && decoded.Block != nil:
+		// "Access to this repository has been disabled by GitHub Staff due to a violation of GitHub's terms of service"
+		if decoded.Block.Reason == "dmca" {
+			return OUTCOME_DMCA
+		}
+		return OUTCOME_TOS_DISABLED
+	}
+	return ""
+}
+
+// verifyRepo checks what happened to a repository. Renamed and transferred repositories are redirected to by
+// GitHub - they come back under their new name.
+func verifyRepo(githubClient *http.Client, previouslyFetchedRepo Repo) (result Verification) {
+	reqUrl := lo.Must(url.Parse(fmt.Sprintf("https://api.github.com/repos/%s", previouslyFetchedRepo.FullName)))
+	req := lo.Must(http.NewRequest("GET", reqUrl.String(), nil))
+	if previouslyFetchedRepo.GetRepoApiLastModifiedHeader != "" {
+		req.Header.Set("If-Modified-Since", previouslyFetchedRepo.GetRepoApiLastModifiedHeader)
+	}
+
+	if config.EnableRequestLog {
+		reqLogger.Println(string(lo.Must(httputil.DumpRequest(req, false))))
+	}
+
+	response, err := githubClient.Do(req)
+	if err != nil {
+		log.Println(fmt.Errorf("[verifier] verifyRepo: could not fetch from github: %w", err))
+		return result
+	}
+	defer response.Body.Close()
+
+	if config.EnableResponsesLog {
+		resLogger.Println(string(lo.Must(httputil.DumpResponse(response, false))))
+	}
+
+	result.Ratelimit = ratelimitFromResponse(response)
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Println(fmt.Errorf("[verifier] verifyRepo: Could not read response body: %w", err))
+		return result
+	}
+
+	if outcome := goneOutcome(response, body); outcome != "" {
+		result.Repo = previouslyFetchedRepo
+		result.Outcome = outcome
+	} else if notModified(response) {
+		// If no changes: return the repository with the same old metadata, but just the last-modified header changed
+		result.Repo = previouslyFetchedRepo
+		result.Repo.GetRepoApiLastModifiedHeader = response.Header.Get("Last-Modified")
+		result.Outcome = OUTCOME_EXISTS
+		result.NotModified = true
+	} else if surelyExists(response) {
+		repo := Repo{}
+		err = json.Unmarshal(body, &repo)
+		if err != nil {
+			log.Println(fmt.Errorf("[verifier] verifyRepo: Could not decode repo json from GitHub: %w", err))
+			return result
+		}
+
+		repo.GetRepoApiLastModifiedHeader = response.Header.Get("Last-Modified")
+
+		// Note: the ID returned by the GitHub /repo endpoint is different from the one returned from the
+		// /search endpoint. Let's override the ID to the search one here to be sure to never use the wrong
+		// one anywhere
+		repo.Id = previouslyFetchedRepo.Id
+
+		result.Repo = repo
+		result.Outcome = OUTCOME_EXISTS
+		if !strings.EqualFold(repo.FullName, previouslyFetchedRepo.FullName) {
+			result.Outcome = OUTCOME_MOVED
+		}
+	} else {
+		log.Printf("[verifier] verifyRepo: Received response code %s from github for %s\n", response.Status, previouslyFetchedRepo.FullName)
+	}
+
+	return result
+}
+
+// getReposToVerify returns the repositories most in need of verifying: the ones not seen by searches for the
+// longest time first, and more popular ones first among those. Gone repositories aren't checked again, and
+// neither are ones already checked since checkedSince.
+func getReposToVerify(db *xorm.Engine, howMany int, checkedSince time.Time) (repos []Repo) {
+	lo.Must0(notGone(inCurrentShard(db.Where("LastSeenCycle < ?", GetCycle(db)-VERIFIER_MIN_NOT_SEEN_CYCLES), "Stargazers").
+		And("(VerifiedAt IS NULL OR VerifiedAt < ?)", checkedSince.Format("2006-01-02 15:04:05"))).
+		Asc("LastSeenCycle").
+		Desc("Stargazers").
+		Asc("Id").
+		Limit(howMany).
+		Find(&repos))
+
+	return repos
+}
+
+// tombstone marks a repository as gone for the given reason - one of GONE_OUTCOMES. The row is kept, so
+// removals can be published. If the repository shows up in search again, save brings it back to life.
+func tombstone(tx xorm.Interface, repoId int64, reason string) {
+	lo.Must(tx.ID(repoId).Cols("VerificationOutcome", "RemovedAt").Update(&Repo{
+		VerificationOutcome: reason,
+		RemovedAt:           time.Now(),
+	}))
+}
+
+// saveVerification records the outcome of a verification. Gone repositories are kept, with the reason why
+// they're gone, so they're not just silently dropped.
+func saveVerification(db *xorm.Engine, verification Verification, previous Repo) {
+	switch verification.Outcome {
+	case "":
+		// Failed - don't check it again in this round
+		lo.Must(db.ID(previous.Id).Cols("VerifiedAt").Update(&Repo{VerifiedAt: time.Now()}))
+	case OUTCOME_EXISTS, OUTCOME_MOVED:
+		repo := verification.Repo
+		repo.VerifiedAt = time.Now()
+		repo.VerificationOutcome = verification.Outcome
+		save(db, GithubSearchResponse{TotalCount: 1, Items: []Repo{repo}})
+	default:
+		log.Printf("[verifier] %s is gone: %s\n", previous.FullName, verification.Outcome)
+		lo.Must(db.Transaction(func(tx *xorm.Session) (any, error) {
+			lo.Must(tx.ID(previous.Id).Cols("VerifiedAt").Update(&Repo{VerifiedAt: time.Now()}))
+			tombstone(tx, previous.Id, verification.Outcome)
+			return nil, nil
+		}))
+	}
+}
+
+// latestRatelimit returns the most up-to-date rate limit out of the responses
+func latestRatelimit(ratelimits []Ratelimit) (latest Ratelimit) {
+	latest.Reset = time.Unix(0, 0)
+
+	for _, ratelimit := range ratelimits {
+		if !ratelimit.Known {
+			continue
+		}
+		latest.Known = true
+
+		if ratelimit.Reset.Unix() == latest.Reset.Unix() {
+			latest.Remaining = min(latest.Remaining, ratelimit.Remaining)
+		} else if ratelimit.Reset.After(latest.Reset) {
+			latest.Reset = ratelimit.Reset
+			latest.Remaining = ratelimit.Remaining
+		}
+	}
+
+	return latest
+}
+
+// availableRatelimit returns how many requests the verifier can still make
+func availableRatelimit(db *xorm.Engine) int {
+	ratelimitReset, ratelimitRemaining := GetRepoRatelimit(db)
+	if time.Until(ratelimitReset) <= -3*time.Second {
+		// The rate limit was reset since it was last seen
+		return DEFAULT_GETREPO_LIMIT - VERIFIER_RATELIMIT_RESERVE
+	}
+	return ratelimitRemaining - VERIFIER_RATELIMIT_RESERVE
+}
+
+// verifyRepos checks repositories that searches stopped finding, until they're all checked, the core API
+// quota runs out, or VERIFIER_TIME_BUDGET passes
+func verifyRepos(ctx context.Context, githubApiClient *http.Client, db *xorm.Engine) {
+	startedAt := time.Now()
+	counts := map[string]int{}
+	failed, notModifiedCount := 0, 0
+
+	for ctx.Err() == nil && time.Since(startedAt) < VERIFIER_TIME_BUDGET {
+		available := availableRatelimit(db)
+		if available <= 0 {
+			ratelimitReset, _ := GetRepoRatelimit(db)
+			log.Printf("[verifier] Out of rate limit, not verifying repositories for the next %d seconds\n", time.Until(ratelimitReset)/time.Second)
+			break
+		}
+
+		toVerify := getReposToVerify(db, min(VERIFIER_PARALLELISM, available), startedAt)
+		if len(toVerify) == 0 {
+			break
+		}
+
+		verifications := parallel.Map(toVerify, func(repo Repo, index int) Verification {
+			return verifyRepo(githubApiClient, repo)
+		})
+
+		for i, verification := range verifications {
+			saveVerification(db, verification, toVerify[i])
+
+			if verification.Outcome == "" {
+				failed++
+			} else {
+				counts[verification.Outcome]++
+			}
+			if verification.NotModified {
+				notModifiedCount++
+			}
+		}
+
+		ratelimits := lo.Map(verifications, func(verification Verification, index int) Ratelimit { return verification.Ratelimit })
+		if ratelimit := latestRatelimit(ratelimits); ratelimit.Known {
+			SetRepoRatelimit(db, ratelimit.Reset, ratelimit.Remaining)
+		}
+	}
+
+	log.Printf("[verifier] Done verifying repos in %v: %d exist (%d not modified), %d moved, %d deleted, %d DMCA, %d disabled for ToS, %d failed\n",
+		time.Since(startedAt).Round(time.Second), counts[OUTCOME_EXISTS], notModifiedCount, counts[OUTCOME_MOVED],
+		counts[OUTCOME_DELETED], counts[OUTCOME_DMCA], counts[OUTCOME_TOS_DISABLED], failed)
+}
@@ -0,0 +1,33 @@
+package fetcher
+
+import (
+	"testing"
+)
+
+func TestNotGone(t *testing.T) {
+	useTestDb(t)
+	db := initialiseDb()
+	defer db.Close()
+
+	repos := []Repo{{Id: 1, FullName: "o/never-verified"}}
+	for i, outcome := range append([]string{OUTCOME_EXISTS, OUTCOME_MOVED}, GONE_OUTCOMES...) {
+		repos = append(repos, Repo{Id: int64(i + 2), FullName: "o/" + outcome, VerificationOutcome: outcome})
+	}
+	if _, err := db.Insert(&repos); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := notGone(db.Table("Repo")).Count(); err != nil || count != 3 {
+		t.Errorf("expected 3 repositories not gone, got %d (%v)", count, err)
+	}
+
+	// Qualified, for joins with tables which have a column of the same name
+	var ids []int64
+	err := notGone(db.Table("Repo").Join("LEFT", "RepoEnrichment", "RepoEnrichment.RepoId = Repo.Id"), "Repo").
+		Asc("Repo.Id").
+		Cols("Repo.Id").
+		Find(&ids)
+	if err != nil || len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("expected repositories 1, 2 and 3 not to be gone, got %v (%v)", ids, err)
+	}
+}
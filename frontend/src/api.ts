@@ -1,22 +1,51 @@
-import { MetadataReponse, ToplistPageResponse } from './apitypes.js';
+import { MetadataReponse, PointerResponse, ToplistPageResponse } from './apitypes.js';
 
 // Use the dev-api-server when working locally
 const BASE_API_URI = ['localhost', '127.0.0.1'].includes(document.location.hostname) 
     ? 'http://127.0.0.1:10002'
     : 'https://data.git-top-repos.net'
 
+// Every upload of the dataset lands in its own versioned directory, the "current" pointer says which one
+// is complete. The dev-api-server serves a plain apifier output directory without a pointer - fall back
+// to the unversioned layout then.
+let currentVersionUri: Promise<string> | null = null
+
+async function fetchCurrentVersionUri(): Promise<string> {
+    try {
+        let response = await fetch(`${BASE_API_URI}/current`);
+        if (!response.ok) {
+            return BASE_API_URI
+        }
+        let pointer: PointerResponse = await response.json();
+        return `${BASE_API_URI}/${pointer.Prefix}`
+    } catch {
+        return BASE_API_URI
+    }
+}
+
+// The apifier publishes records with booleans, RFC 3339 timestamps and nulls under v2/ - the unprefixed
+// files are kept for clients from before that
+const FORMAT_PREFIX = 'v2'
+
+async function dataUri(): Promise<string> {
+    if (currentVersionUri === null) {
+        currentVersionUri = fetchCurrentVersionUri()
+    }
+    return `${await currentVersionUri}/${FORMAT_PREFIX}`
+}
+
 export async function getMetadata(): Promise<MetadataReponse> {
-    let response = await fetch(`${BASE_API_URI}/metadata`);
+    let response = await fetch(`${await dataUri()}/metadata`);
     return await response.json();
 }
 
 export async function languageToplistPage(escapedLanguageName: string, pageNumber: number): Promise<ToplistPageResponse> {
-    let response = await fetch(`${BASE_API_URI}/language/${escapedLanguageName}/${pageNumber}`);
+    let response = await fetch(`${await dataUri()}/language/${escapedLanguageName}/${pageNumber}`);
     return await response.json();
 }
 
 export async function allLanguagesToplistPage(pageNumber: number): Promise<ToplistPageResponse> {
-    let response = await fetch(`${BASE_API_URI}/all/${pageNumber}`);
+    let response = await fetch(`${await dataUri()}/all/${pageNumber}`);
     return await response.json();
 }
 
@@ -31,9 +60,9 @@ function preloadJsonLink(link: string) {
 }
 
 export async function preloadLanguageToplistPage(escapedLanguageName: string, pageNumber: number) {
-    preloadJsonLink(`${BASE_API_URI}/language/${escapedLanguageName}/${pageNumber}`)
+    preloadJsonLink(`${await dataUri()}/language/${escapedLanguageName}/${pageNumber}`)
 }
 
 export async function preloadAllLanguagesToplistPage(pageNumber: number) {
-    preloadJsonLink(`${BASE_API_URI}/all/${pageNumber}`)
+    preloadJsonLink(`${await dataUri()}/all/${pageNumber}`)
 }
\ No newline at end of file
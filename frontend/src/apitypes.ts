@@ -1,9 +1,19 @@
+export interface PointerResponse {
+    Version: string
+    Prefix: string
+    PublishedAt: string
+}
+
 export interface MetadataReponse {
+    SchemaVersion: number
+    MinimumStars: number
     CountOfAllRepos: number
     CountOfAllStars: number
     AllReposPages: number
     LastSyncTime: string
     Languages: Language[]
+    CountOfRemovedRepos: number
+    RemovedPages: number
 }
 
 export interface Language {
@@ -17,18 +27,54 @@ export interface Language {
 export type ToplistPageResponse = Repository[]
 
 export interface Repository {
-    Archived: number
-    CreatedAt: string
-    Description: string
+    Archived: boolean
+    CreatedAt: null | string
+    Description: null | string
     GithubLink: string
-    Homepage: string
-    Language: string
-    LicenseSpdxId: string
-    LicenseName: string
+    Homepage: null | string
+    Id: number
+    Language: null | string
+    LicenseSpdxId: null | string
+    LicenseName: null | string
     Name: string
     OwnerAvatarUrl: string
     OwnerLogin: string
     RepoPushedAt: null | string
-    RepoUpdatedAt: string
+    RepoUpdatedAt: null | string
+    Stargazers: number
+    Stale: boolean
+
+    // Only if the apifier was run with -extra-fields, null for repositories not fetched since these were added
+    Forks?: null | number
+    Watchers?: null | number
+    Size?: null | number
+    Fork?: null | boolean
+    IsTemplate?: null | boolean
+    DefaultBranch?: null | string
+    HasPages?: null | boolean
+    Visibility?: null | string
+
+    // Also only with -extra-fields, missing until the fetcher's enrichment worker gets to a repository
+    LatestRelease?: string
+    LatestReleasePublishedAt?: string
+    Contributors?: number
+    // Bytes of code per language, most used first
+    Languages?: Record<string, number>
+}
+
+// Previous "owner/name"s of renamed or transferred repositories, mapped to their current ones
+export type RedirectsResponse = Record<string, string>
+
+// removed/N - popular repositories that recently disappeared from GitHub
+export type RemovedPageResponse = RemovedRepository[]
+
+export interface RemovedRepository {
+    Id: number
+    FullName: string
+    GithubLink: string
+    Description: null | string
+    Language: null | string
     Stargazers: number
+    Reason: 'deleted' | 'dmca' | 'tos-disabled' | 'replaced'
+    RemovedAt: null | string
 }
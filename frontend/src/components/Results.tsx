@@ -121,10 +121,14 @@ function Repo(repo: Repository): JSX.Element {
                             ? <span className="badge bg-warning text-dark rounded-pill m-1">Archived</span>
                             : <></>
                         }
+                        {repo.Stale
+                            ? <span className="badge bg-secondary rounded-pill m-1" title="Not seen on GitHub recently - might have been deleted">Stale</span>
+                            : <></>
+                        }
                         {lastPushedToBadge}
                         {createdAtBadge}
                         {repo.LicenseSpdxId && repo.LicenseSpdxId != 'NOASSERTION'
-                            ? <span className="badge bg-info text-dark rounded-pill m-1" title={repo.LicenseName}>{repo.LicenseSpdxId}</span>
+                            ? <span className="badge bg-info text-dark rounded-pill m-1" title={repo.LicenseName ?? undefined}>{repo.LicenseSpdxId}</span>
                             : <></>
                         }
                     </div>
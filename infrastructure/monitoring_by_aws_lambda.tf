@@ -89,7 +89,7 @@ resource "aws_lambda_function" "check_last_modified" {
     variables = {
       SENDER_EMAIL_ADDRESS = var.monitoring_warning_emails_from
       TARGET_EMAIL_ADDRESS = var.monitoring_warning_emails_to
-      METADATA_URL         = "https://data.${var.domain_name}/metadata"
+      METADATA_URL         = "https://data.${var.domain_name}/current"
     }
   }
 
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/karolba/top-of-github/uploader"
+)
+
+const backupKey = "repos.db.gz"
+
+// snapshotDatabase makes a consistent copy of the live database with VACUUM INTO - which, unlike copying
+// the file, is safe while the database is in WAL mode and something is still writing to it
+func snapshotDatabase(ctx context.Context, from string, to string) error {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?mode=ro&_busy_timeout=5000", from))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "vacuum into ?", to)
+	return err
+}
+
+// checkIntegrity runs sqlite's integrity check on the database at path
+func checkIntegrity(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "pragma integrity_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	problems := []string{}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// gzipFile compresses the file at from into a new file at to
+func gzipFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	gzipWriter := gzip.NewWriter(target)
+	if _, err := io.Copy(gzipWriter, source); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return target.Close()
+}
+
+// gunzipFile decompresses the file at from into a new file at to. Files which aren't gzipped are copied
+// as they are - objects uploaded with "Content-Encoding: gzip" can come back already decompressed.
+func gunzipFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	buffered := bufio.NewReader(source)
+	var reader io.Reader = buffered
+
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	target, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	if _, err := io.Copy(target, reader); err != nil {
+		return err
+	}
+	return target.Close()
+}
+
+func backupUploaderConfig(bucketName string) uploader.Config {
+	config := uploader.DefaultConfig()
+	config.Directory = *backupDirectory
+	config.BucketName = bucketName
+	config.ContentType = "application/x-sqlite3"
+	config.SummaryFile = filepath.Join(*stateDirectory, "backup-upload-summary.json")
+	return config
+}
+
+// backupDatabase snapshots the database, checks the snapshot's integrity, and uploads it compressed
+func backupDatabase(ctx context.Context) error {
+	bucketName, err := requiredEnvironmentVariable("R2_DB_BACKUP_BUCKET_NAME")
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(*backupDirectory); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*backupDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(*backupDirectory)
+
+	// Kept outside of the uploaded directory, so only the compressed file is uploaded
+	snapshotPath := *databasePath + ".snapshot"
+	if err := os.Remove(snapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	defer os.Remove(snapshotPath)
+
+	log.Printf("[backup] Taking a snapshot of %s\n", *databasePath)
+	if err := snapshotDatabase(ctx, *databasePath, snapshotPath); err != nil {
+		return fmt.Errorf("could not take a snapshot of the database: %w", err)
+	}
+
+	log.Println("[backup] Checking the integrity of the snapshot")
+	if err := checkIntegrity(ctx, snapshotPath); err != nil {
+		return err
+	}
+
+	log.Println("[backup] Compressing the snapshot")
+	err = gzipFile(snapshotPath, filepath.Join(*backupDirectory, backupKey))
+	if err != nil {
+		return fmt.Errorf("could not compress the database: %w", err)
+	}
+
+	_, err = uploader.Run(ctx, backupUploaderConfig(bucketName))
+	return err
+}
+
+// restoreDatabase downloads the latest backup, checks its integrity, and swaps it in place of the current
+// database. The current database is kept next to it, with a ".before-restore" suffix.
+func restoreDatabase(ctx context.Context) error {
+	bucketName, err := requiredEnvironmentVariable("R2_DB_BACKUP_BUCKET_NAME")
+	if err != nil {
+		return err
+	}
+
+	// Downloaded next to the database, so it can be renamed into place
+	downloadedPath := *databasePath + ".restore.gz"
+	restoredPath := *databasePath + ".restore"
+	defer os.Remove(downloadedPath)
+	defer os.Remove(restoredPath)
+
+	log.Printf("[restore] Downloading %s from %s\n", backupKey, bucketName)
+	if err := uploader.Download(ctx, backupUploaderConfig(bucketName), backupKey, downloadedPath); err != nil {
+		return fmt.Errorf("could not download the backup: %w", err)
+	}
+
+	log.Println("[restore] Decompressing the backup")
+	if err := gunzipFile(downloadedPath, restoredPath); err != nil {
+		return fmt.Errorf("could not decompress the backup: %w", err)
+	}
+
+	log.Println("[restore] Checking the integrity of the backup")
+	if err := checkIntegrity(ctx, restoredPath); err != nil {
+		return err
+	}
+
+	// The -wal and -shm files belong to the old database - left in place, sqlite would apply them to the
+	// restored one. They're moved together with it.
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		err := os.Rename(*databasePath+suffix, *databasePath+".before-restore"+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not move the current database out of the way: %w", err)
+		}
+	}
+
+	if err := os.Rename(restoredPath, *databasePath); err != nil {
+		return err
+	}
+
+	log.Printf("[restore] Restored %s, the previous database is at %s\n", *databasePath, *databasePath+".before-restore")
+	return nil
+}
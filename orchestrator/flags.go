@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karolba/top-of-github/orchestrator/purge"
+)
+
+// stepDurations is a flag value of the form "step=duration,step=duration"
+type stepDurations map[string]time.Duration
+
+func (s stepDurations) String() string {
+	parts := []string{}
+	for step, duration := range s {
+		parts = append(parts, fmt.Sprintf("%s=%v", step, duration))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s stepDurations) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		step, durationString, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected step=duration, got '%s'", part)
+		}
+		duration, err := time.ParseDuration(durationString)
+		if err != nil {
+			return err
+		}
+		s[strings.TrimSpace(step)] = duration
+	}
+	return nil
+}
+
+// stepCounts is a flag value of the form "step=count,step=count"
+type stepCounts map[string]int
+
+func (s stepCounts) String() string {
+	parts := []string{}
+	for step, count := range s {
+		parts = append(parts, fmt.Sprintf("%s=%d", step, count))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s stepCounts) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		step, countString, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected step=count, got '%s'", part)
+		}
+		count, err := strconv.Atoi(countString)
+		if err != nil {
+			return err
+		}
+		s[strings.TrimSpace(step)] = count
+	}
+	return nil
+}
+
+var (
+	stateDirectory   = flag.String("state-dir", "state", "Where the database, the run history and the lock file are kept")
+	databasePath     = flag.String("database", "state/repos.db", "Path to the sqlite database to use")
+	minimumStars     = flag.Int64("minimum-stars", 5, "Metadata about repositories of this many stars and up will be downloaded")
+	exportMinStars   = flag.Int64("export-minimum-stars", 0, "Publish only repositories with this many stars and up. 0 means the same as -minimum-stars")
+	outputDirectory  = flag.String("output-dir", "to-upload", "Where the apifier saves generated json files before they're uploaded")
+	backupDirectory  = flag.String("backup-dir", "to-upload-backup", "Where the database backup is prepared before it's uploaded")
+	schedule         = flag.String("schedule", "", "Cron-like schedule (minute hour day-of-month month day-of-week) to run the pipeline on. Runs back-to-back if empty")
+	once             = flag.Bool("once", false, "Run the pipeline once and exit")
+	failureCooldown  = flag.Duration("failure-cooldown", 10*time.Minute, "How long to wait after a failed pipeline run before starting another one (without -schedule)")
+	stepTimeouts     = stepDurations{"apifier": 6 * time.Hour, "upload": 6 * time.Hour, "vacuum": 6 * time.Hour, "backup": 6 * time.Hour, "purge-cache": 5 * time.Minute}
+	stepRetries      = stepCounts{"upload": 2, "backup": 2, "purge-cache": 3}
+	stepRetryBackoff = flag.Duration("step-retry-backoff", time.Minute, "How long to wait before retrying a failed step")
+	cdn              = flag.String("cdn", "cloudflare", "Whose cache to purge after an upload: cloudflare, webhook or none")
+	publicUrl        = flag.String("public-url", "", "Base URL uploaded files are served from. Defaults to https://$CLOUDFLARE_PURGE_CACHE_DOMAIN")
+	cloudflareApiUrl = flag.String("cloudflare-api-url", purge.CloudflareDefaultApiUrl, "Base URL of the Cloudflare API - can point to a local stand-in, like the dev-api-server")
+	purgeWebhookUrl  = flag.String("purge-webhook-url", "", "With -cdn=webhook: where to POST {\"urls\": [...]} to purge them")
+	purgeBatchSize   = flag.Int("purge-batch-size", 0, "How many URLs to purge in one request. 0 means the CDN's default")
+)
+
+func init() {
+	flag.Var(stepTimeouts, "step-timeouts", "Timeouts of pipeline steps, as step=duration pairs separated by commas. Steps without one can run forever")
+	flag.Var(stepRetries, "step-retries", "How many times to retry failed pipeline steps, as step=count pairs separated by commas")
+	flag.Parse()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepSkipped   = "skipped"
+)
+
+type StepRecord struct {
+	Name        string
+	Status      string
+	Attempts    int
+	Error       string `json:",omitempty"`
+	Fingerprint string `json:",omitempty"`
+	StartedAt   time.Time
+	FinishedAt  time.Time
+}
+
+type RunRecord struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Succeeded  bool
+	Steps      []StepRecord
+}
+
+func historyPath() string {
+	return filepath.Join(*stateDirectory, "orchestrator-history.jsonl")
+}
+
+// appendToHistory saves a finished pipeline run as one line of json
+func appendToHistory(run RunRecord) error {
+	runJson, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(historyPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(runJson, '\n'))
+	return err
+}
+
+// lastSuccessfulFingerprints returns, for every step, the fingerprint it had when it last succeeded
+func lastSuccessfulFingerprints() (map[string]string, error) {
+	fingerprints := map[string]string{}
+
+	file, err := os.Open(historyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return fingerprints, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var run RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return nil, err
+		}
+		for _, step := range run.Steps {
+			if step.Status == StepSucceeded && step.Fingerprint != "" {
+				fingerprints[step.Name] = step.Fingerprint
+			}
+		}
+	}
+
+	return fingerprints, scanner.Err()
+}
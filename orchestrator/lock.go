@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// acquireLock makes sure only one orchestrator works on the state directory at a time. The lock is
+// released by the operating system when the process exits, even if it crashes.
+func acquireLock() (*os.File, error) {
+	path := filepath.Join(*stateDirectory, "orchestrator.lock")
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not lock %s - is another orchestrator running? %w", path, err)
+	}
+
+	return file, nil
+}
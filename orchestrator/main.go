@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// waitForNextRun sleeps until the pipeline should be run again
+func waitForNextRun(ctx context.Context, cron *Schedule, previousRun *RunRecord) error {
+	if cron != nil {
+		next := cron.Next(time.Now())
+		log.Printf("[orchestrator] Next run at %v\n", next)
+		return sleepContext(ctx, time.Until(next))
+	}
+
+	if previousRun != nil && !previousRun.Succeeded {
+		log.Printf("[orchestrator] The previous run failed, waiting %v before the next one\n", *failureCooldown)
+		return sleepContext(ctx, *failureCooldown)
+	}
+
+	return nil
+}
+
+// runCommand runs a one-off command instead of the pipeline, and exits
+func runCommand(ctx context.Context, lock *os.File, command func(context.Context) error) {
+	err := command(ctx)
+	lock.Close()
+	if err != nil {
+		log.Fatalln("Error:", err)
+	}
+	os.Exit(0)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := loadDotEnv(".env"); err != nil {
+		log.Fatalln("Could not load .env:", err)
+	}
+
+	var cron *Schedule
+	if *schedule != "" {
+		var err error
+		cron, err = ParseSchedule(*schedule)
+		if err != nil {
+			log.Fatalln("Invalid -schedule:", err)
+		}
+	}
+
+	if err := os.MkdirAll(*stateDirectory, os.ModePerm); err != nil {
+		log.Fatalln(err)
+	}
+
+	lock, err := acquireLock()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer lock.Close()
+
+	switch flag.Arg(0) {
+	case "", "run":
+	case "backup":
+		runCommand(ctx, lock, backupDatabase)
+	case "restore":
+		runCommand(ctx, lock, restoreDatabase)
+	default:
+		log.Fatalf("Unknown command '%s' - expected run, backup or restore\n", flag.Arg(0))
+	}
+
+	var previousRun *RunRecord
+	for {
+		if *once {
+			run := runPipeline(ctx, pipelineSteps())
+			if !run.Succeeded {
+				lock.Close()
+				os.Exit(1)
+			}
+			return
+		}
+
+		if err := waitForNextRun(ctx, cron, previousRun); err != nil {
+			log.Println("[orchestrator] Stopping:", err)
+			return
+		}
+
+		run := runPipeline(ctx, pipelineSteps())
+		previousRun = &run
+
+		if ctx.Err() != nil {
+			log.Println("[orchestrator] Stopping:", ctx.Err())
+			return
+		}
+	}
+}
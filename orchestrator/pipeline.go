@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Step is a single stage of the pipeline
+type Step struct {
+	Name string
+	Run  func(ctx context.Context) error
+
+	// Optional: if the fingerprint is the same as when the step last succeeded, the step is skipped
+	Fingerprint func() (string, error)
+
+	// Optional: the step is skipped if the named step was skipped in the same run
+	SkipIfSkipped string
+}
+
+// The builtin time.Sleep function doesn't take context.Context into account
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// attempt runs a step once, within its timeout. Panics - which is how the fetcher and the apifier
+// report most errors - are turned into errors.
+func attempt(ctx context.Context, step Step) (err error) {
+	if timeout, ok := stepTimeouts[step.Name]; ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if caught := recover(); caught != nil {
+			err = fmt.Errorf("panic: %v", caught)
+		}
+	}()
+
+	return step.Run(ctx)
+}
+
+func runStep(ctx context.Context, step Step, record *StepRecord) error {
+	retries := stepRetries[step.Name]
+
+	for {
+		record.Attempts++
+		log.Printf("[orchestrator] %s - starting (attempt %d of %d)\n", step.Name, record.Attempts, retries+1)
+
+		err := attempt(ctx, step)
+		if err == nil {
+			log.Printf("[orchestrator] %s - succeeded\n", step.Name)
+			return nil
+		}
+
+		log.Printf("[orchestrator] %s - failed: %v\n", step.Name, err)
+		if record.Attempts > retries || ctx.Err() != nil {
+			return err
+		}
+
+		if err := sleepContext(ctx, *stepRetryBackoff); err != nil {
+			return err
+		}
+	}
+}
+
+// runPipeline runs all steps in order, stopping at the first one that fails
+func runPipeline(ctx context.Context, steps []Step) RunRecord {
+	run := RunRecord{StartedAt: time.Now()}
+
+	fingerprints, err := lastSuccessfulFingerprints()
+	if err != nil {
+		log.Printf("[orchestrator] Could not read the run history, not skipping any steps: %v\n", err)
+		fingerprints = map[string]string{}
+	}
+
+	skipped := map[string]bool{}
+	run.Succeeded = true
+
+	for _, step := range steps {
+		record := StepRecord{Name: step.Name, StartedAt: time.Now()}
+
+		if step.SkipIfSkipped != "" && skipped[step.SkipIfSkipped] {
+			log.Printf("[orchestrator] %s - skipped, because %s was skipped\n", step.Name, step.SkipIfSkipped)
+			record.Status = StepSkipped
+		} else if step.Fingerprint != nil {
+			record.Fingerprint, err = step.Fingerprint()
+			if err != nil {
+				log.Printf("[orchestrator] %s - could not compute a fingerprint, running anyway: %v\n", step.Name, err)
+				record.Fingerprint = ""
+			} else if record.Fingerprint == fingerprints[step.Name] {
+				log.Printf("[orchestrator] %s - skipped, nothing changed since it last succeeded\n", step.Name)
+				record.Status = StepSkipped
+			}
+		}
+
+		if record.Status != StepSkipped {
+			err := runStep(ctx, step, &record)
+			if err != nil {
+				record.Status = StepFailed
+				record.Error = err.Error()
+				run.Succeeded = false
+			} else {
+				record.Status = StepSucceeded
+			}
+		} else {
+			skipped[step.Name] = true
+		}
+
+		record.FinishedAt = time.Now()
+		run.Steps = append(run.Steps, record)
+
+		if !run.Succeeded {
+			break
+		}
+	}
+
+	run.FinishedAt = time.Now()
+
+	if err := appendToHistory(run); err != nil {
+		log.Printf("[orchestrator] Could not save the run to the history: %v\n", err)
+	}
+
+	return run
+}
@@ -0,0 +1,102 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	CloudflareDefaultApiUrl = "https://api.cloudflare.com/client/v4"
+
+	// Cloudflare accepts at most this many URLs in a single purge request (on most plans)
+	cloudflareMaxBatchSize = 30
+)
+
+// Cloudflare purges files by URL with the Cloudflare API
+type Cloudflare struct {
+	client    *http.Client
+	apiUrl    string
+	zoneId    string
+	apiToken  string
+	batchSize int
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func NewCloudflare(config Config) (*Cloudflare, error) {
+	if config.CloudflareZoneId == "" || config.CloudflareApiToken == "" {
+		return nil, errors.New("purging Cloudflare's cache needs a zone id and an API token")
+	}
+
+	cloudflare := &Cloudflare{
+		client:    config.httpClient(),
+		apiUrl:    strings.TrimSuffix(config.CloudflareApiUrl, "/"),
+		zoneId:    config.CloudflareZoneId,
+		apiToken:  config.CloudflareApiToken,
+		batchSize: cloudflareMaxBatchSize,
+	}
+	if cloudflare.apiUrl == "" {
+		cloudflare.apiUrl = CloudflareDefaultApiUrl
+	}
+	if config.BatchSize > 0 {
+		cloudflare.batchSize = config.BatchSize
+	}
+
+	return cloudflare, nil
+}
+
+func (c *Cloudflare) MaxBatchSize() int {
+	return c.batchSize
+}
+
+func (c *Cloudflare) Purge(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(map[string][]string{"files": urls})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/zones/%s/purge_cache", c.apiUrl, c.zoneId), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	var decoded cloudflareResponse
+	if err := json.Unmarshal(responseBody, &decoded); err != nil {
+		return fmt.Errorf("unexpected response from Cloudflare (%s): %s", response.Status, string(responseBody))
+	}
+
+	if response.StatusCode != http.StatusOK || !decoded.Success {
+		messages := []string{}
+		for _, e := range decoded.Errors {
+			messages = append(messages, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("Cloudflare refused to purge the cache (%s): %s", response.Status, strings.Join(messages, "; "))
+	}
+
+	return nil
+}
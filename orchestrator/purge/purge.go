@@ -0,0 +1,88 @@
+// Package purge invalidates CDN caches for objects that changed in the bucket
+package purge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Purger removes the given URLs from a CDN's cache
+type Purger interface {
+	// Purge invalidates at most MaxBatchSize() URLs at once
+	Purge(ctx context.Context, urls []string) error
+	MaxBatchSize() int
+}
+
+// New creates a purger by name: "cloudflare", "webhook" or "none"
+func New(name string, config Config) (Purger, error) {
+	switch name {
+	case "cloudflare":
+		return NewCloudflare(config)
+	case "webhook":
+		return NewWebhook(config)
+	case "none":
+		return Noop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CDN purger '%s'", name)
+	}
+}
+
+// Config has the settings of all purgers - each one only uses its own
+type Config struct {
+	HttpClient *http.Client
+
+	CloudflareApiUrl   string
+	CloudflareZoneId   string
+	CloudflareApiToken string
+
+	WebhookUrl string
+
+	BatchSize int
+}
+
+func (c Config) httpClient() *http.Client {
+	if c.HttpClient != nil {
+		return c.HttpClient
+	}
+	return http.DefaultClient
+}
+
+// URLs turns object keys into the public URLs they're served from
+func URLs(publicBaseUrl string, keys []string) []string {
+	urls := make([]string, 0, len(keys))
+	for _, key := range keys {
+		urls = append(urls, strings.TrimSuffix(publicBaseUrl, "/")+"/"+strings.TrimPrefix(key, "/"))
+	}
+	return urls
+}
+
+// InBatches purges all urls, split into batches the purger accepts
+func InBatches(ctx context.Context, purger Purger, urls []string) error {
+	batchSize := max(purger.MaxBatchSize(), 1)
+
+	for start := 0; start < len(urls); start += batchSize {
+		batch := urls[start:min(start+batchSize, len(urls))]
+
+		err := purger.Purge(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("could not purge URLs %d-%d of %d: %w", start+1, start+len(batch), len(urls), err)
+		}
+		log.Printf("[purge] Purged %d URLs (%d of %d)\n", len(batch), start+len(batch), len(urls))
+	}
+
+	return nil
+}
+
+// Noop doesn't purge anything - for setups without a CDN
+type Noop struct{}
+
+func (Noop) Purge(ctx context.Context, urls []string) error {
+	return nil
+}
+
+func (Noop) MaxBatchSize() int {
+	return 1000
+}
@@ -0,0 +1,134 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// cloudflareStub stands in for Cloudflare's API, recording the files of every purge request
+func cloudflareStub(t *testing.T, respond func(w http.ResponseWriter)) (server *httptest.Server, batches *[][]string) {
+	t.Helper()
+	batches = &[][]string{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/zones/zone-id/purge_cache" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if authorization := r.Header.Get("Authorization"); authorization != "Bearer api-token" {
+			t.Errorf("unexpected Authorization header %q", authorization)
+		}
+
+		var body struct {
+			Files []string `json:"files"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("could not decode the purge request: %v", err)
+		}
+		*batches = append(*batches, body.Files)
+		respond(w)
+	}))
+	t.Cleanup(server.Close)
+	return server, batches
+}
+
+func newTestCloudflare(t *testing.T, server *httptest.Server) *Cloudflare {
+	t.Helper()
+	cloudflare, err := NewCloudflare(Config{
+		HttpClient:         server.Client(),
+		CloudflareApiUrl:   server.URL + "/",
+		CloudflareZoneId:   "zone-id",
+		CloudflareApiToken: "api-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cloudflare
+}
+
+func TestCloudflarePurgesChangedKeysInBatches(t *testing.T) {
+	server, batches := cloudflareStub(t, func(w http.ResponseWriter) {
+		w.Write([]byte(`{"success": true, "errors": [], "result": {"id": "zone-id"}}`))
+	})
+	cloudflare := newTestCloudflare(t, server)
+
+	keys := []string{}
+	for i := 1; i <= 2*cloudflareMaxBatchSize+5; i++ {
+		keys = append(keys, fmt.Sprintf("/all/%d", i))
+	}
+	urls := URLs("https://cdn.example/", keys)
+	if err := InBatches(context.Background(), cloudflare, urls); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*batches) != 3 {
+		t.Fatalf("expected 3 purge requests, got %d", len(*batches))
+	}
+	for i, expected := range [][]string{urls[:cloudflareMaxBatchSize], urls[cloudflareMaxBatchSize : 2*cloudflareMaxBatchSize], urls[2*cloudflareMaxBatchSize:]} {
+		if !reflect.DeepEqual((*batches)[i], expected) {
+			t.Errorf("expected purge request %d to have %v, got %v", i+1, expected, (*batches)[i])
+		}
+	}
+	if (*batches)[0][0] != "https://cdn.example/all/1" {
+		t.Errorf("expected keys to be purged by their public URLs, got %s", (*batches)[0][0])
+	}
+}
+
+func TestCloudflareReturnsRefusalsAsErrors(t *testing.T) {
+	for name, respond := range map[string]func(w http.ResponseWriter){
+		"unsuccessful": func(w http.ResponseWriter) {
+			w.Write([]byte(`{"success": false, "errors": [{"code": 1012, "message": "Request must contain one of purge_everything, files, tags, hosts or prefixes"}]}`))
+		},
+		"error status": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"success": false, "errors": [{"code": 10000, "message": "Authentication error"}]}`))
+		},
+		"not JSON": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`<html>Bad gateway</html>`))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, batches := cloudflareStub(t, respond)
+			cloudflare := newTestCloudflare(t, server)
+
+			urls := URLs("https://cdn.example", []string{"all/1", "all/2", "metadata"})
+			if err := InBatches(context.Background(), cloudflare, urls); err == nil {
+				t.Errorf("expected an error when Cloudflare doesn't purge the cache")
+			}
+			if len(*batches) != 1 {
+				t.Errorf("expected purging to stop after the first refused request, got %d requests", len(*batches))
+			}
+		})
+	}
+}
+
+func TestWebhookReturnsErrorStatusesAsErrors(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Urls []string `json:"urls"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("could not decode the webhook request: %v", err)
+		}
+		received = body.Urls
+		http.Error(w, "purging is down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhook(Config{HttpClient: server.Client(), WebhookUrl: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := []string{"https://cdn.example/all/1"}
+	if err := webhook.Purge(context.Background(), urls); err == nil {
+		t.Errorf("expected an error when the webhook responds with an error status")
+	}
+	if !reflect.DeepEqual(received, urls) {
+		t.Errorf("expected the webhook to get %v, got %v", urls, received)
+	}
+}
@@ -0,0 +1,67 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const webhookDefaultBatchSize = 100
+
+// Webhook POSTs {"urls": [...]} to an arbitrary URL - for CDNs without a built-in purger
+type Webhook struct {
+	client    *http.Client
+	url       string
+	batchSize int
+}
+
+func NewWebhook(config Config) (*Webhook, error) {
+	if config.WebhookUrl == "" {
+		return nil, errors.New("purging with a webhook needs the webhook's URL")
+	}
+
+	webhook := &Webhook{
+		client:    config.httpClient(),
+		url:       config.WebhookUrl,
+		batchSize: webhookDefaultBatchSize,
+	}
+	if config.BatchSize > 0 {
+		webhook.batchSize = config.BatchSize
+	}
+
+	return webhook, nil
+}
+
+func (w *Webhook) MaxBatchSize() int {
+	return w.batchSize
+}
+
+func (w *Webhook) Purge(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(map[string][]string{"urls": urls})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("the purge webhook responded with %s: %s", response.Status, string(responseBody))
+	}
+
+	return nil
+}
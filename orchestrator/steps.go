@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/karolba/top-of-github/apifier"
+	"github.com/karolba/top-of-github/fetcher"
+	"github.com/karolba/top-of-github/orchestrator/purge"
+	"github.com/karolba/top-of-github/uploader"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func requiredEnvironmentVariable(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", fmt.Errorf("missing required environment variable %s", name)
+	}
+	return value, nil
+}
+
+func pipelineSteps() []Step {
+	return []Step{
+		{Name: "fetcher", Run: fetchStep},
+		{Name: "apifier", Run: apifierStep},
+		// "metadata" contains the time it was generated at, so it's different every time
+		{Name: "upload", Run: uploadStep, Fingerprint: func() (string, error) {
+			return directoryFingerprint(*outputDirectory, "metadata", "v2/metadata")
+		}},
+		{Name: "vacuum", Run: vacuumStep},
+		{Name: "backup", Run: backupStep},
+		// Runs whenever there's an upload summary it hasn't successfully purged yet - also when purging
+		// failed in an earlier run whose upload succeeded
+		{Name: "purge-cache", Run: purgeCacheStep, Fingerprint: func() (string, error) { return fileFingerprint(uploadSummaryPath()) }},
+	}
+}
+
+func uploadSummaryPath() string {
+	return filepath.Join(*stateDirectory, "upload-summary.json")
+}
+
+func fetchStep(ctx context.Context) error {
+	config := fetcher.DefaultConfig()
+	config.DatabasePath = *databasePath
+	config.MinimumStars = *minimumStars
+	return fetcher.Run(ctx, config)
+}
+
+func apifierStep(ctx context.Context) error {
+	err := os.RemoveAll(*outputDirectory)
+	if err != nil {
+		return err
+	}
+
+	config := apifier.DefaultConfig()
+	config.DatabasePath = *databasePath
+	config.OutputDir = *outputDirectory
+	config.MinimumStars = *exportMinStars
+	return apifier.Run(ctx, config)
+}
+
+func uploadStep(ctx context.Context) error {
+	bucketName, err := requiredEnvironmentVariable("R2_PUBLIC_BUCKET_NAME")
+	if err != nil {
+		return err
+	}
+
+	config := uploader.DefaultConfig()
+	config.Directory = *outputDirectory
+	config.BucketName = bucketName
+	config.Versioned = true
+	config.JournalPath = filepath.Join(*stateDirectory, "upload-journal")
+	config.SummaryFile = uploadSummaryPath()
+
+	_, err = uploader.Run(ctx, config)
+	return err
+}
+
+func vacuumStep(ctx context.Context) error {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?mode=rw&_busy_timeout=5000&_journal_mode=WAL", *databasePath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, "pragma temp_store_directory='.'; vacuum")
+	return err
+}
+
+func backupStep(ctx context.Context) error {
+	return backupDatabase(ctx)
+}
+
+// purgeCacheStep purges the keys the last upload changed from the CDN's cache
+func purgeCacheStep(ctx context.Context) error {
+	summaryJson, err := os.ReadFile(uploadSummaryPath())
+	if err != nil {
+		return fmt.Errorf("could not read the upload summary: %w", err)
+	}
+
+	var summary uploader.Summary
+	if err := json.Unmarshal(summaryJson, &summary); err != nil {
+		return fmt.Errorf("could not parse the upload summary: %w", err)
+	}
+	if len(summary.ChangedKeys) == 0 {
+		return nil
+	}
+
+	purger, err := newPurger()
+	if err != nil {
+		return err
+	}
+
+	baseUrl := *publicUrl
+	if baseUrl == "" {
+		domain, err := requiredEnvironmentVariable("CLOUDFLARE_PURGE_CACHE_DOMAIN")
+		if err != nil {
+			return err
+		}
+		baseUrl = "https://" + domain
+	}
+
+	return purge.InBatches(ctx, purger, purge.URLs(baseUrl, summary.ChangedKeys))
+}
+
+func newPurger() (purge.Purger, error) {
+	config := purge.Config{
+		CloudflareApiUrl: *cloudflareApiUrl,
+		WebhookUrl:       *purgeWebhookUrl,
+		BatchSize:        *purgeBatchSize,
+	}
+
+	if *cdn == "cloudflare" {
+		var err error
+		if config.CloudflareZoneId, err = requiredEnvironmentVariable("CLOUDFLARE_PURGE_CACHE_ZONE"); err != nil {
+			return nil, err
+		}
+		if config.CloudflareApiToken, err = requiredEnvironmentVariable("CLOUDFLARE_PURGE_CACHE_TOKEN"); err != nil {
+			return nil, err
+		}
+	}
+
+	return purge.New(*cdn, config)
+}
+
+// fileFingerprint hashes the contents of a single file
+func fileFingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// directoryFingerprint hashes the names and contents of all files in a directory - apart from the
+// ones listed in ignored, relative to the directory
+func directoryFingerprint(directory string, ignored ...string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(ignored, filepath.ToSlash(relativePath)) {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		fmt.Fprintf(hash, "%s\x00", filepath.ToSlash(relativePath))
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
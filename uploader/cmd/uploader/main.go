@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/karolba/top-of-github/uploader"
+)
+
+var logFormat = flag.String("log-format", "json", "Format of logs and progress events written to stderr: 'json' or 'text'")
+
+func main() {
+	config := uploader.DefaultConfig()
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	switch *logFormat {
+	case "json":
+		// Makes every log line - including ones from the log package - a structured event
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	case "text":
+	default:
+		log.Println("Error: -log-format has to be either 'json' or 'text'")
+		os.Exit(1)
+	}
+
+	summary, err := uploader.Run(context.Background(), config)
+	if err != nil {
+		log.Println("Error:", err)
+	}
+
+	summaryJson, jsonErr := json.Marshal(summary)
+	if jsonErr != nil {
+		log.Println("Could not encode the summary:", jsonErr)
+	} else {
+		fmt.Println(string(summaryJson))
+	}
+
+	if err != nil && summary.Errors == 0 {
+		// Failed before uploading anything
+		os.Exit(1)
+	}
+
+	// Quoting os.Exit's documentation:
+	// "For portability, the status code should be in the range [0, 125]."
+	// let's cap the exit code to 125 for that
+	os.Exit(int(min(summary.Errors, 125)))
+}
@@ -1,43 +1,123 @@
-package main
+package uploader
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
 	"os"
+	"time"
 )
 
-var (
-	ACCOUNT_ID        = haveToGetEnvironmentVariable("R2_ACCOUNT_ID")
-	ACCESS_KEY_ID     = haveToGetEnvironmentVariable("R2_ACCESS_KEY_ID")
-	ACCESS_KEY_SECRET = haveToGetEnvironmentVariable("R2_ACCESS_KEY_SECRET")
-)
+// Config is everything an uploader run can be configured with
+type Config struct {
+	Directory       string
+	BucketName      string
+	ContentType     string
+	ContentEncoding string
+	CacheControl    string
 
-var (
-	targetDirectory = flag.String("directory", ".", "A directory to upload")
-	bucketName      = flag.String("bucket-name", "", "Target bucket name")
-	contentType     = flag.String("content-type", "application/json", "Content-Type for uploaded files")
-	contentEncoding = flag.String("content-encoding", "gzip", "Content-Type for uploaded files")
-	cacheControl    = flag.String("cache-control", "public, max-age=86400, stale-if-error=86400, stale-while-revalidate=86400", "The Cache-Control header for uploaded files")
-)
+	Versioned           bool
+	PointerKey          string
+	PointerCacheControl string
+	VersionRetention    time.Duration
+
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+	JournalPath      string
+
+	MultipartThreshold   int64
+	MultipartPartSize    int64
+	MultipartParallelism int
+
+	ProgressInterval time.Duration
+	SummaryFile      string
+
+	AccountId       string
+	AccessKeyId     string
+	AccessKeySecret string
+}
 
-func init() {
-	flag.Parse()
+// The config of the currently running upload
+var config = DefaultConfig()
 
-	if bucketName == nil || *bucketName == "" {
-		log.Println("Error: The -bucket-name parameter is mandatory")
-		os.Exit(1)
+// DefaultConfig returns the default configuration, with R2 credentials taken from the environment
+func DefaultConfig() Config {
+	return Config{
+		Directory:       ".",
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		CacheControl:    "public, max-age=86400, stale-if-error=86400, stale-while-revalidate=86400",
+
+		PointerKey:          "current",
+		PointerCacheControl: "public, max-age=60, stale-if-error=86400",
+		VersionRetention:    72 * time.Hour,
+
+		RetryMaxAttempts: 6,
+		RetryBaseDelay:   500 * time.Millisecond,
+		RetryMaxDelay:    30 * time.Second,
+
+		MultipartThreshold:   64 * 1024 * 1024,
+		MultipartPartSize:    16 * 1024 * 1024,
+		MultipartParallelism: 4,
+
+		ProgressInterval: 10 * time.Second,
+
+		AccountId:       os.Getenv("R2_ACCOUNT_ID"),
+		AccessKeyId:     os.Getenv("R2_ACCESS_KEY_ID"),
+		AccessKeySecret: os.Getenv("R2_ACCESS_KEY_SECRET"),
 	}
 }
 
-func haveToGetEnvironmentVariable(name string) string {
-	val, ok := os.LookupEnv(name)
-	if !ok {
-		log.Printf("Missing required environment variable %s\n", name)
-		os.Exit(1)
+// RegisterFlags binds the config to command line flags
+func (c *Config) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&c.Directory, "directory", c.Directory, "A directory to upload")
+	flags.StringVar(&c.BucketName, "bucket-name", c.BucketName, "Target bucket name")
+	flags.StringVar(&c.ContentType, "content-type", c.ContentType, "Content-Type for uploaded files")
+	flags.StringVar(&c.ContentEncoding, "content-encoding", c.ContentEncoding, "Content-Type for uploaded files")
+	flags.StringVar(&c.CacheControl, "cache-control", c.CacheControl, "The Cache-Control header for uploaded files")
+
+	flags.BoolVar(&c.Versioned, "versioned", c.Versioned, "Upload under a new v/<timestamp>/ prefix and switch the pointer object to it once every file is uploaded")
+	flags.StringVar(&c.PointerKey, "pointer-key", c.PointerKey, "Key of the pointer object naming the current version (with -versioned)")
+	flags.StringVar(&c.PointerCacheControl, "pointer-cache-control", c.PointerCacheControl, "The Cache-Control header for the pointer object (with -versioned)")
+	flags.DurationVar(&c.VersionRetention, "version-retention", c.VersionRetention, "Delete versions replaced longer ago than this after publishing a new one - the one just replaced is always kept (with -versioned)")
+
+	flags.IntVar(&c.RetryMaxAttempts, "retry-max-attempts", c.RetryMaxAttempts, "Maximum number of attempts to upload a single file")
+	flags.DurationVar(&c.RetryBaseDelay, "retry-base-delay", c.RetryBaseDelay, "Delay before the first retry, doubled with every next one (and jittered)")
+	flags.DurationVar(&c.RetryMaxDelay, "retry-max-delay", c.RetryMaxDelay, "Maximum delay between retries")
+	flags.StringVar(&c.JournalPath, "journal", c.JournalPath, "Record uploaded files in this file, to resume a failed upload from where it stopped")
+
+	flags.Int64Var(&c.MultipartThreshold, "multipart-threshold", c.MultipartThreshold, "Upload files of at least this many bytes in multiple parts")
+	flags.Int64Var(&c.MultipartPartSize, "multipart-part-size", c.MultipartPartSize, "Size of a single part of a multipart upload, in bytes (at least 5MiB)")
+	flags.IntVar(&c.MultipartParallelism, "multipart-parallelism", c.MultipartParallelism, "How many parts of a single multipart upload to upload at once")
+
+	flags.DurationVar(&c.ProgressInterval, "progress-interval", c.ProgressInterval, "How often to log a progress event")
+	flags.StringVar(&c.SummaryFile, "summary-file", c.SummaryFile, "Also save the final JSON summary to this file")
+}
+
+func (c *Config) validate() error {
+	if c.BucketName == "" {
+		return errors.New("the bucket name is mandatory")
+	}
+
+	if c.MultipartPartSize < minimumPartSize {
+		return fmt.Errorf("the multipart part size has to be at least %d bytes", minimumPartSize)
+	}
+
+	if c.MultipartParallelism < 1 {
+		return errors.New("the multipart parallelism has to be at least 1")
 	}
-	if val == "" {
-		log.Printf("Required environment variable %s is empty\n", name)
-		os.Exit(1)
+
+	required := map[string]string{
+		"R2_ACCOUNT_ID":        c.AccountId,
+		"R2_ACCESS_KEY_ID":     c.AccessKeyId,
+		"R2_ACCESS_KEY_SECRET": c.AccessKeySecret,
+	}
+	for name, value := range required {
+		if value == "" {
+			return fmt.Errorf("missing required environment variable %s", name)
+		}
 	}
-	return val
+
+	return nil
 }
@@ -0,0 +1,63 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Download saves the object under key in cfg.BucketName to path, retrying like uploads do. The object is
+// written to a temporary file next to path, and only renamed to path once it's complete.
+func Download(ctx context.Context, cfg Config, key string, path string) error {
+	config = cfg
+	stats = newStats()
+
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	client, err := cloudflareR2Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	return retry(ctx, key, func() error {
+		return download(ctx, client, key, path)
+	})
+}
+
+func download(ctx context.Context, client *s3.Client, key string, path string) error {
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer object.Body.Close()
+
+	temporaryPath := path + ".download"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryPath)
+	defer file.Close()
+
+	written, err := io.Copy(file, object.Body)
+	if err != nil {
+		return err
+	}
+	if object.ContentLength > 0 && written != object.ContentLength {
+		return fmt.Errorf("downloaded %d bytes of %s, expected %d", written, key, object.ContentLength)
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, path)
+}
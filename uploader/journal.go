@@ -0,0 +1,138 @@
+package uploader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Journal records which files have already been uploaded, so that a failed run can be resumed
+// instead of restarting from scratch.
+//
+// It's a plain text file, one entry per line:
+//
+//	version <version>
+//	done <size> <modification time in unix nanoseconds> <key>
+//
+// A file is only skipped if it still has the same size and modification time as when it was uploaded.
+type Journal struct {
+	path      string
+	file      *os.File
+	mutex     sync.Mutex
+	completed map[string]string
+	Version   string
+}
+
+func journalFingerprint(info os.FileInfo) string {
+	return fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+}
+
+// openJournal reads the journal left over by a previous run (if there's any) and opens it for appending
+func openJournal(path string) (*Journal, error) {
+	journal := &Journal{
+		path:      path,
+		completed: map[string]string{},
+	}
+
+	existing, err := os.Open(path)
+	if err == nil {
+		defer existing.Close()
+
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			kind, rest, _ := strings.Cut(scanner.Text(), " ")
+			switch kind {
+			case "version":
+				journal.Version = rest
+			case "done":
+				size, rest, _ := strings.Cut(rest, " ")
+				modTime, key, _ := strings.Cut(rest, " ")
+				journal.completed[key] = size + " " + modTime
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read journal %s: %w", path, err)
+		}
+
+		log.Printf("[journal] Resuming from %s: %d files already uploaded\n", path, len(journal.completed))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	journal.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+func (j *Journal) appendLine(line string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	_, err := j.file.WriteString(line + "\n")
+	return err
+}
+
+// SetVersion starts the journal over for the version uploaded files belong to - files uploaded under
+// another version don't count anymore
+func (j *Journal) SetVersion(version string) error {
+	j.mutex.Lock()
+	j.Version = version
+	j.completed = map[string]string{}
+	err := j.file.Truncate(0)
+	j.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return j.appendLine("version " + version)
+}
+
+// MatchesFiles tells whether every file the journal recorded as uploaded is still in the directory, unchanged.
+// If not, the directory holds a newer export - resuming its version would mix files of the two.
+func (j *Journal) MatchesFiles(directory string) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for key, fingerprint := range j.completed {
+		path := strings.TrimPrefix(key, versionPrefix(j.Version))
+		info, err := os.Stat(filepath.Join(directory, filepath.FromSlash(path)))
+		if err != nil || journalFingerprint(info) != fingerprint {
+			return false
+		}
+	}
+	return true
+}
+
+// Completed tells whether the file was already uploaded under the given key, and hasn't changed since
+func (j *Journal) Completed(key string, info os.FileInfo) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	fingerprint, ok := j.completed[key]
+	return ok && fingerprint == journalFingerprint(info)
+}
+
+func (j *Journal) MarkCompleted(key string, info os.FileInfo) error {
+	return j.appendLine("done " + journalFingerprint(info) + " " + key)
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Remove deletes the journal - to be called once a run finished successfully and there's nothing to resume
+func (j *Journal) Remove() error {
+	err := j.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
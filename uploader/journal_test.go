@@ -0,0 +1,124 @@
+package uploader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, content string, modTime time.Time) os.FileInfo {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// interruptedJournal leaves a journal behind like a versioned run which uploaded some files and then failed
+func interruptedJournal(t *testing.T, directory string) string {
+	t.Helper()
+	journalPath := filepath.Join(t.TempDir(), "journal")
+	journal, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	if err := journal.SetVersion("20260101T000000Z"); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, path := range []string{"all/1", "language/Go/1"} {
+		info := writeTestFile(t, filepath.Join(directory, filepath.FromSlash(path)), "[]", modTime)
+		if err := journal.MarkCompleted(objectKey(journal.Version, path), info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return journalPath
+}
+
+func TestJournalMatchesUnchangedFiles(t *testing.T) {
+	directory := t.TempDir()
+	journal, err := openJournal(interruptedJournal(t, directory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	if journal.Version != "20260101T000000Z" {
+		t.Errorf("expected the journal's version to be read back, got %q", journal.Version)
+	}
+	if !journal.MatchesFiles(directory) {
+		t.Errorf("expected a journal of unchanged files to match them")
+	}
+}
+
+func TestJournalDoesNotMatchChangedFiles(t *testing.T) {
+	for name, change := range map[string]func(t *testing.T, directory string){
+		"rewritten": func(t *testing.T, directory string) {
+			writeTestFile(t, filepath.Join(directory, "all", "1"), "[{}]", time.Now())
+		},
+		"removed": func(t *testing.T, directory string) {
+			if err := os.Remove(filepath.Join(directory, "language", "Go", "1")); err != nil {
+				t.Fatal(err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			directory := t.TempDir()
+			journalPath := interruptedJournal(t, directory)
+			change(t, directory)
+
+			journal, err := openJournal(journalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer journal.Close()
+
+			if journal.MatchesFiles(directory) {
+				t.Errorf("expected a journal of changed files not to match them")
+			}
+		})
+	}
+}
+
+func TestJournalSetVersionStartsOver(t *testing.T) {
+	directory := t.TempDir()
+	journalPath := interruptedJournal(t, directory)
+
+	journal, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(directory, "all", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.SetVersion("20260102T000000Z"); err != nil {
+		t.Fatal(err)
+	}
+	if journal.Completed(objectKey("20260101T000000Z", "all/1"), info) {
+		t.Errorf("expected files uploaded under the abandoned version to be forgotten")
+	}
+	journal.Close()
+
+	reopened, err := openJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Version != "20260102T000000Z" || len(reopened.completed) != 0 {
+		t.Errorf("expected the journal file to only have the new version, got %q with %d files", reopened.Version, len(reopened.completed))
+	}
+}
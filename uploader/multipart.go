@@ -0,0 +1,215 @@
+package uploader
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	minimumPartSize = 5 * 1024 * 1024 // S3 doesn't accept smaller parts (apart from the last one)
+	maximumParts    = 10000           // S3 doesn't accept more parts than this
+)
+
+type uploadedPart struct {
+	number int32
+	md5    []byte
+	eTag   string
+	err    error
+}
+
+// partSize picks the configured part size, unless the file is so big that it wouldn't fit in maximumParts
+func partSize(fileSize int64) int64 {
+	size := config.MultipartPartSize
+	if fileSize > size*maximumParts {
+		size = (fileSize + maximumParts - 1) / maximumParts
+	}
+	return size
+}
+
+func md5OfSection(file *os.File, offset int64, size int64) ([]byte, error) {
+	hash := md5.New()
+	_, err := io.Copy(hash, io.NewSectionReader(file, offset, size))
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func unquoteETag(eTag *string) string {
+	return strings.Trim(aws.ToString(eTag), `"`)
+}
+
+// multipartETag computes the ETag S3 gives to objects uploaded in multiple parts: a hash of all the
+// parts' hashes, suffixed with the number of parts
+func multipartETag(parts []uploadedPart) string {
+	hash := md5.New()
+	for _, part := range parts {
+		hash.Write(part.md5)
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(parts))
+}
+
+// uploadPart uploads (with retries) a single part, verifying its checksum on both sides
+func uploadPart(ctx context.Context, client *s3.Client, key string, uploadId *string, file *os.File, number int32, offset int64, size int64) uploadedPart {
+	part := uploadedPart{number: number}
+
+	part.md5, part.err = md5OfSection(file, offset, size)
+	if part.err != nil {
+		return part
+	}
+
+	part.err = retry(ctx, fmt.Sprintf("part %d of %s", number, key), func() error {
+		output, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(config.BucketName),
+			Key:           aws.String(key),
+			UploadId:      uploadId,
+			PartNumber:    number,
+			Body:          io.NewSectionReader(file, offset, size),
+			ContentLength: size,
+			// Makes the server reject the part if it got corrupted on the way
+			ContentMD5: aws.String(base64.StdEncoding.EncodeToString(part.md5)),
+		})
+		if err != nil {
+			return err
+		}
+		part.eTag = aws.ToString(output.ETag)
+		return nil
+	})
+	if part.err != nil {
+		return part
+	}
+
+	if unquoteETag(&part.eTag) != hex.EncodeToString(part.md5) {
+		part.err = fmt.Errorf("checksum mismatch for part %d of %s: uploaded %x, got ETag %s", number, key, part.md5, part.eTag)
+	}
+
+	return part
+}
+
+// uploadMultipart uploads a big file in parts, a few at a time, and verifies the checksum of the result
+func uploadMultipart(ctx context.Context, client *s3.Client, key string, path string, fileSize int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var created *s3.CreateMultipartUploadOutput
+	err = retry(ctx, key, func() error {
+		created, err = client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:             aws.String(config.BucketName),
+			Key:                aws.String(key),
+			ContentType:        aws.String(config.ContentType),
+			ContentEncoding:    aws.String(config.ContentEncoding),
+			ContentDisposition: aws.String("inline"),
+			CacheControl:       aws.String(config.CacheControl),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not start a multipart upload: %w", err)
+	}
+
+	size := partSize(fileSize)
+	partCount := int((fileSize + size - 1) / size)
+	log.Printf("[multipart] Uploading %s in %d parts of %d bytes\n", key, partCount, size)
+
+	parts := make([]uploadedPart, partCount)
+	semaphore := make(chan struct{}, config.MultipartParallelism)
+	var wg sync.WaitGroup
+
+	for i := 0; i < partCount; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			offset := int64(i) * size
+			parts[i] = uploadPart(ctx, client, key, created.UploadId, file, int32(i+1), offset, min(size, fileSize-offset))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, part := range parts {
+		if part.err != nil {
+			abortMultipart(client, key, created.UploadId)
+			return part.err
+		}
+	}
+
+	completedParts := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completedParts = append(completedParts, types.CompletedPart{
+			ETag:       aws.String(part.eTag),
+			PartNumber: part.number,
+		})
+	}
+
+	var completed *s3.CompleteMultipartUploadOutput
+	err = retry(ctx, key, func() error {
+		completed, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(config.BucketName),
+			Key:             aws.String(key),
+			UploadId:        created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+		})
+		return err
+	})
+	if err != nil {
+		abortMultipart(client, key, created.UploadId)
+		return fmt.Errorf("could not complete a multipart upload: %w", err)
+	}
+
+	return verifyMultipart(ctx, client, key, parts, fileSize, completed.ETag)
+}
+
+// verifyMultipart checks whether what ended up in the bucket is what was uploaded
+func verifyMultipart(ctx context.Context, client *s3.Client, key string, parts []uploadedPart, fileSize int64, eTag *string) error {
+	expectedETag := multipartETag(parts)
+	if unquoteETag(eTag) != expectedETag {
+		return fmt.Errorf("checksum mismatch for %s: expected ETag %s, got %s", key, expectedETag, aws.ToString(eTag))
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("could not verify the size of %s: %w", key, err)
+	}
+	if head.ContentLength != fileSize {
+		return fmt.Errorf("size mismatch for %s: uploaded %d bytes, the bucket has %d", key, fileSize, head.ContentLength)
+	}
+
+	log.Printf("[multipart] Uploaded and verified %s (ETag %s)\n", key, expectedETag)
+	return nil
+}
+
+// abortMultipart gets rid of the parts already uploaded - so they don't linger in the bucket forever.
+// It's done even if the upload was cancelled, hence the separate context.
+func abortMultipart(client *s3.Client, key string, uploadId *string) {
+	_, err := client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(config.BucketName),
+		Key:      aws.String(key),
+		UploadId: uploadId,
+	})
+	if err != nil {
+		log.Printf("[multipart] Could not abort the multipart upload of %s: %v\n", key, err)
+	}
+}
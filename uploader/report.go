@@ -0,0 +1,154 @@
+package uploader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts what happened during the upload. It's updated concurrently by all the uploading goroutines.
+type Stats struct {
+	startedAt     time.Time
+	filesTotal    atomic.Int64
+	filesUploaded atomic.Int64
+	filesSkipped  atomic.Int64
+	filesFailed   atomic.Int64
+	bytesUploaded atomic.Int64
+	bytesSkipped  atomic.Int64
+	retries       atomic.Int64
+	errors        atomic.Int64
+
+	changedKeysMutex sync.Mutex
+	changedKeys      []string
+}
+
+// The stats of the currently running upload
+var stats = newStats()
+
+func newStats() *Stats {
+	return &Stats{startedAt: time.Now()}
+}
+
+// Summary is the final report of an uploader run, printed as a single JSON object to stdout
+type Summary struct {
+	Bucket                   string
+	Version                  string `json:",omitempty"`
+	Published                bool
+	Succeeded                bool
+	FilesTotal               int64
+	FilesUploaded            int64
+	FilesSkipped             int64
+	FilesFailed              int64
+	BytesUploaded            int64
+	BytesSkipped             int64
+	Retries                  int64
+	Errors                   int64
+	StartedAt                string
+	FinishedAt               string
+	DurationSeconds          float64
+	ThroughputBytesPerSecond float64
+
+	// Keys whose publicly visible content changed - the ones to purge from a CDN's cache
+	ChangedKeys []string
+}
+
+// addChangedKey records a key that now serves different content than before the upload
+func (s *Stats) addChangedKey(key string) {
+	s.changedKeysMutex.Lock()
+	defer s.changedKeysMutex.Unlock()
+	s.changedKeys = append(s.changedKeys, key)
+}
+
+func (s *Stats) sortedChangedKeys() []string {
+	s.changedKeysMutex.Lock()
+	defer s.changedKeysMutex.Unlock()
+
+	keys := append([]string{}, s.changedKeys...)
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Stats) filesDone() int64 {
+	return s.filesUploaded.Load() + s.filesSkipped.Load() + s.filesFailed.Load()
+}
+
+func (s *Stats) logProgress() {
+	filesTotal := s.filesTotal.Load()
+	filesDone := s.filesDone()
+
+	percent := 100.0
+	if filesTotal > 0 {
+		percent = float64(filesDone) / float64(filesTotal) * 100
+	}
+
+	slog.Info("progress",
+		"files_done", filesDone,
+		"files_total", filesTotal,
+		"percent", fmt.Sprintf("%.2f", percent),
+		"files_uploaded", s.filesUploaded.Load(),
+		"files_skipped", s.filesSkipped.Load(),
+		"files_failed", s.filesFailed.Load(),
+		"bytes_uploaded", s.bytesUploaded.Load(),
+		"retries", s.retries.Load())
+}
+
+// reportProgress logs a progress event every config.ProgressInterval, until done is closed
+func reportProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(config.ProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stats.logProgress()
+		case <-done:
+			stats.logProgress()
+			return
+		}
+	}
+}
+
+func (s *Stats) Summary(version string, published bool) Summary {
+	finishedAt := time.Now()
+	duration := finishedAt.Sub(s.startedAt)
+
+	return Summary{
+		Bucket:                   config.BucketName,
+		Version:                  version,
+		Published:                published,
+		Succeeded:                s.errors.Load() == 0,
+		FilesTotal:               s.filesTotal.Load(),
+		FilesUploaded:            s.filesUploaded.Load(),
+		FilesSkipped:             s.filesSkipped.Load(),
+		FilesFailed:              s.filesFailed.Load(),
+		BytesUploaded:            s.bytesUploaded.Load(),
+		BytesSkipped:             s.bytesSkipped.Load(),
+		Retries:                  s.retries.Load(),
+		Errors:                   s.errors.Load(),
+		StartedAt:                s.startedAt.UTC().Format(time.RFC3339),
+		FinishedAt:               finishedAt.UTC().Format(time.RFC3339),
+		DurationSeconds:          duration.Seconds(),
+		ThroughputBytesPerSecond: float64(s.bytesUploaded.Load()) / max(duration.Seconds(), 0.001),
+		ChangedKeys:              s.sortedChangedKeys(),
+	}
+}
+
+// saveSummary saves the summary as json to config.SummaryFile
+func saveSummary(summary Summary) {
+	summaryJson, err := json.Marshal(summary)
+	if err != nil {
+		log.Println("Could not encode the summary:", err)
+		return
+	}
+
+	err = os.WriteFile(config.SummaryFile, append(summaryJson, '\n'), 0666)
+	if err != nil {
+		log.Printf("Could not save the summary to %s: %v\n", config.SummaryFile, err)
+	}
+}
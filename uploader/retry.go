@@ -0,0 +1,103 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Error codes S3 (and R2) return for conditions that are expected to go away on their own
+var retryableErrorCodes = map[string]bool{
+	"InternalError":        true,
+	"RequestTimeout":       true,
+	"RequestTimeTooSkewed": true,
+	"ServiceUnavailable":   true,
+	"SlowDown":             true,
+	"ThrottlingException":  true,
+}
+
+// Satisfied by the SDK's smithy.APIError, for errors S3 returned an error code for
+type apiError interface {
+	ErrorCode() string
+}
+
+// Satisfied by the SDK's http.ResponseError, for errors that happened after getting an HTTP response
+type httpResponseError interface {
+	HTTPStatusCode() int
+}
+
+// isRetryable tells whether it's worth it to try to upload a file again after getting an error
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr apiError
+	if errors.As(err, &apiErr) && retryableErrorCodes[apiErr.ErrorCode()] {
+		return true
+	}
+
+	var responseErr httpResponseError
+	if errors.As(err, &responseErr) {
+		status := responseErr.HTTPStatusCode()
+		return status >= 500 || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// No response at all, but not a network error either - most likely a failure in the middle of
+	// sending the request. Better safe than sorry.
+	return !errors.As(err, &apiErr)
+}
+
+// backoff returns how long to wait before the given (1-indexed) retry attempt. It's an exponential
+// backoff with "full jitter" - so that many concurrent uploads failing at once don't retry in lockstep.
+func backoff(attempt int) time.Duration {
+	ceiling := config.RetryMaxDelay
+	if attempt < 32 {
+		ceiling = min(config.RetryMaxDelay, config.RetryBaseDelay<<(attempt-1))
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retry runs the operation with a maximum number of attempts, backing off exponentially between them.
+// Errors that won't go away by retrying are returned right away.
+func retry(ctx context.Context, description string, operation func() error) error {
+	attempt := 1
+	for {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil || !isRetryable(err) {
+			return fmt.Errorf("non-retryable error: %w", err)
+		}
+
+		if attempt >= config.RetryMaxAttempts {
+			return fmt.Errorf("maximum retry attempts exceeded: %w", err)
+		}
+
+		stats.retries.Add(1)
+		sleepFor := backoff(attempt)
+		log.Printf("Error for %s (attempt %d): %v. Retrying in %v...", description, attempt, err, sleepFor)
+
+		attempt++
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cancelled while retrying: %w", err)
+		case <-time.After(sleepFor):
+		}
+	}
+}
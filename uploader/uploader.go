@@ -0,0 +1,324 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	maxUploads = 16 // Maximum number of concurrent uploads
+)
+
+func cloudflareR2Client(ctx context.Context) (*s3.Client, error) {
+	r2Resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{
+			URL: fmt.Sprintf("https://%s.r2.cloudflarestorage.com", config.AccountId),
+		}, nil
+	})
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithEndpointResolverWithOptions(r2Resolver),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.AccessKeyId, config.AccessKeySecret, "")),
+		// Retries are done by retryUpload, which knows how to rewind the request body
+		awsconfig.WithRetryer(func() aws.Retryer { return aws.NopRetryer{} }),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(cfg), nil
+}
+
+// Run uploads every file in config.Directory to the bucket, keyed by its path relative to the directory.
+// Failures of single files don't stop the upload - they're counted in the returned Summary, and make
+// Run return an error. Only one Run can happen at a time.
+func Run(ctx context.Context, cfg Config) (Summary, error) {
+	config = cfg
+	stats = newStats()
+
+	if err := config.validate(); err != nil {
+		return Summary{}, err
+	}
+
+	var journal *Journal
+	if config.JournalPath != "" {
+		var err error
+		journal, err = openJournal(config.JournalPath)
+		if err != nil {
+			return Summary{}, fmt.Errorf("could not open the journal %v: %w", config.JournalPath, err)
+		}
+	}
+
+	client, err := cloudflareR2Client(ctx)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	// With config.Versioned, every file is uploaded under a fresh prefix, so clients never see a mix of
+	// old and new files. They are only switched over to it after everything is uploaded.
+	version := ""
+	if config.Versioned {
+		resumable := journal != nil && journal.Version != "" && journal.MatchesFiles(config.Directory)
+		if resumable {
+			version = journal.Version
+			log.Printf("[versioning] Resuming the upload of version %s\n", version)
+		} else {
+			if journal != nil && journal.Version != "" {
+				// Left under its prefix - it was never published, so it's garbage collected like old versions
+				log.Printf("[versioning] Files changed since version %s was partly uploaded - abandoning it\n", journal.Version)
+			}
+			version = newVersion(time.Now())
+			log.Printf("[versioning] Uploading as version %s\n", version)
+		}
+
+		if journal != nil && !resumable {
+			err = journal.SetVersion(version)
+			if err != nil {
+				return Summary{}, fmt.Errorf("could not write to the journal: %w", err)
+			}
+		}
+	}
+
+	// Get the total number of files in the directory
+	stats.filesTotal.Store(int64(countFiles(config.Directory)))
+
+	// Report progress periodically until all uploads are done
+	uploadsDone := make(chan struct{})
+	progressReported := make(chan struct{})
+	go func() {
+		reportProgress(uploadsDone)
+		close(progressReported)
+	}()
+
+	// Create a wait group to ensure all uploads are completed
+	var wg sync.WaitGroup
+
+	// Create a semaphore to control concurrent uploads
+	semaphore := make(chan struct{}, maxUploads)
+
+	// Upload files
+	err = filepath.WalkDir(config.Directory, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			log.Println("Error:", err)
+			stats.errors.Add(1)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !d.IsDir() {
+			wg.Add(1)
+			semaphore <- struct{}{} // Acquire semaphore
+
+			go func() {
+				defer func() {
+					<-semaphore // Release semaphore
+					wg.Done()
+				}()
+
+				relativePath, err := filepath.Rel(config.Directory, path)
+				if err != nil {
+					log.Println("Error:", err)
+					stats.filesFailed.Add(1)
+					stats.errors.Add(1)
+					return
+				}
+				key := objectKey(version, filepath.ToSlash(relativePath))
+
+				info, err := d.Info()
+				if err != nil {
+					log.Println("Error:", err)
+					stats.filesFailed.Add(1)
+					stats.errors.Add(1)
+					return
+				}
+
+				if journal != nil && journal.Completed(key, info) {
+					stats.filesSkipped.Add(1)
+					stats.bytesSkipped.Add(info.Size())
+					// Uploaded by an earlier, interrupted run - which never got to report it as changed
+					if !config.Versioned {
+						stats.addChangedKey(key)
+					}
+					return
+				}
+
+				// Upload file to S3 with retry
+				err = retryUpload(ctx, client, key, path)
+				if err != nil {
+					log.Println("Upload error:", err)
+					stats.filesFailed.Add(1)
+					stats.errors.Add(1)
+					return
+				}
+
+				stats.filesUploaded.Add(1)
+				stats.bytesUploaded.Add(info.Size())
+
+				// A versioned upload goes under a fresh prefix nobody could have cached yet
+				if !config.Versioned {
+					stats.addChangedKey(key)
+				}
+
+				if journal != nil {
+					err = journal.MarkCompleted(key, info)
+					if err != nil {
+						log.Println("Could not write to the journal:", err)
+					}
+				}
+			}()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		stats.errors.Add(1)
+		log.Println("Error:", err)
+	}
+
+	// Wait for all uploads to complete
+	wg.Wait()
+
+	close(uploadsDone)
+	<-progressReported
+
+	published := false
+	if config.Versioned {
+		published = publishVersion(ctx, client, version)
+	}
+
+	if journal != nil {
+		closeJournal(journal, stats.errors.Load() == 0)
+	}
+
+	summary := stats.Summary(version, published)
+	if config.SummaryFile != "" {
+		saveSummary(summary)
+	}
+
+	if summary.Errors > 0 {
+		return summary, fmt.Errorf("%d errors while uploading %s", summary.Errors, config.Directory)
+	}
+	return summary, nil
+}
+
+// publishVersion switches the pointer over to a fully uploaded version and cleans up the old ones
+func publishVersion(ctx context.Context, client *s3.Client, version string) (published bool) {
+	if stats.errors.Load() != 0 {
+		log.Printf("[versioning] Not publishing version %s - some files failed to upload\n", version)
+		return false
+	}
+
+	previous, err := readPointer(ctx, client)
+	if err != nil {
+		// Without the versions it replaced, the previous one could be garbage collected under its clients
+		log.Println("Error:", err)
+		stats.errors.Add(1)
+		return false
+	}
+
+	pointer := newPointer(version, previous, time.Now(), config.VersionRetention)
+	err = publishPointer(ctx, client, pointer)
+	if err != nil {
+		log.Println("Error:", err)
+		stats.errors.Add(1)
+		return false
+	}
+	stats.addChangedKey(config.PointerKey)
+
+	err = garbageCollectVersions(ctx, client, pointer, config.VersionRetention)
+	if err != nil {
+		// The new version is already published at this point - failing to clean up is not fatal,
+		// it will be retried with the next upload
+		log.Println("[versioning] Garbage collection error:", err)
+	}
+	return true
+}
+
+// closeJournal gets rid of the journal after a successful run, or keeps it around for the next one to resume
+func closeJournal(journal *Journal, succeeded bool) {
+	var err error
+	if succeeded {
+		err = journal.Remove()
+	} else {
+		log.Printf("[journal] Keeping %s - the next run will resume from it\n", config.JournalPath)
+		err = journal.Close()
+	}
+	if err != nil {
+		log.Println("[journal] Error:", err)
+	}
+}
+
+// upload puts a single file in the bucket. The file is opened anew on every call, so a retried
+// upload never sends a body that was already partially read.
+func upload(ctx context.Context, client *s3.Client, key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(config.BucketName),
+		Key:                aws.String(key),
+		Body:               file,
+		ContentType:        aws.String(config.ContentType),
+		ContentEncoding:    aws.String(config.ContentEncoding),
+		ContentDisposition: aws.String("inline"),
+		CacheControl:       aws.String(config.CacheControl),
+	})
+	return err
+}
+
+// retryUpload retries the upload operation with a maximum number of attempts. Big files are uploaded
+// in parts, each one retried separately - so that a single failure doesn't restart the whole transfer.
+func retryUpload(ctx context.Context, client *s3.Client, key string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() >= config.MultipartThreshold {
+		return uploadMultipart(ctx, client, key, path, info.Size())
+	}
+
+	return retry(ctx, key, func() error {
+		return upload(ctx, client, key, path)
+	})
+}
+
+// countFiles counts the total number of files in the given directory (recursively)
+func countFiles(directoryPath string) int {
+	count := 0
+
+	err := filepath.WalkDir(directoryPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			log.Println("Error:", err)
+			return nil
+		}
+
+		if !d.IsDir() {
+			count++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Println("Error:", err)
+	}
+
+	return count
+}
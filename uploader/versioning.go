@@ -0,0 +1,233 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	versionsPrefix = "v/"               // All published versions live under this prefix
+	versionFormat  = "20060102T150405Z" // Versions are named after the (UTC) time they were started at
+	maxDeleteBatch = 1000               // DeleteObjects accepts at most this many keys at once
+)
+
+// Pointer is the small object telling clients which version of the dataset is the current one.
+// It is uploaded last - only after every file of a version has been uploaded successfully.
+type Pointer struct {
+	Version     string
+	Prefix      string
+	PublishedAt string
+	// Versions which were current before this one, the latest first. Clients keep using the version they
+	// read from the pointer, so versions are only garbage collected a retention period after being replaced.
+	Replaced []ReplacedVersion `json:",omitempty"`
+}
+
+// ReplacedVersion is a version which used to be the current one
+type ReplacedVersion struct {
+	Version    string
+	ReplacedAt string
+}
+
+// newPointer points to the version, remembering the previous pointer's version as replaced now. Versions
+// replaced longer than the retention period ago are forgotten - they're due for garbage collection anyway.
+func newPointer(version string, previous Pointer, now time.Time, retention time.Duration) Pointer {
+	pointer := Pointer{
+		Version:     version,
+		Prefix:      strings.TrimSuffix(versionPrefix(version), "/"),
+		PublishedAt: now.UTC().Format(time.RFC3339),
+	}
+
+	if previous.Version != "" && previous.Version != version {
+		pointer.Replaced = append(pointer.Replaced, ReplacedVersion{Version: previous.Version, ReplacedAt: pointer.PublishedAt})
+	}
+	for _, replaced := range previous.Replaced {
+		replacedAt, err := time.Parse(time.RFC3339, replaced.ReplacedAt)
+		if err != nil || now.Sub(replacedAt) >= retention || replaced.Version == version {
+			continue
+		}
+		pointer.Replaced = append(pointer.Replaced, replaced)
+	}
+	return pointer
+}
+
+// readPointer returns the currently published pointer - or an empty one, if nothing was published yet
+func readPointer(ctx context.Context, client *s3.Client) (pointer Pointer, err error) {
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(config.BucketName),
+		Key:    aws.String(config.PointerKey),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return pointer, nil
+	}
+	if err != nil {
+		return pointer, fmt.Errorf("could not read the pointer '%s': %w", config.PointerKey, err)
+	}
+	defer output.Body.Close()
+
+	err = json.NewDecoder(output.Body).Decode(&pointer)
+	if err != nil {
+		return pointer, fmt.Errorf("could not decode the pointer '%s': %w", config.PointerKey, err)
+	}
+	return pointer, nil
+}
+
+func newVersion(startedAt time.Time) string {
+	return startedAt.UTC().Format(versionFormat)
+}
+
+func versionPrefix(version string) string {
+	return versionsPrefix + version + "/"
+}
+
+// objectKey returns the key a file at a given path should be uploaded to
+func objectKey(version string, path string) string {
+	if version == "" {
+		return path
+	}
+	return versionPrefix(version) + path
+}
+
+// publishPointer atomically switches clients over to the pointer's version
+func publishPointer(ctx context.Context, client *s3.Client, pointer Pointer) error {
+	pointerJson, err := json.Marshal(pointer)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(config.BucketName),
+		Key:                aws.String(config.PointerKey),
+		Body:               bytes.NewReader(pointerJson),
+		ContentType:        aws.String("application/json"),
+		ContentDisposition: aws.String("inline"),
+		CacheControl:       aws.String(config.PointerCacheControl),
+	})
+	if err != nil {
+		return fmt.Errorf("could not publish the pointer to version %s: %w", pointer.Version, err)
+	}
+
+	log.Printf("[versioning] Published version %s in '%s'\n", pointer.Version, config.PointerKey)
+	return nil
+}
+
+// publishedVersions lists the names of all versions present in the bucket
+func publishedVersions(ctx context.Context, client *s3.Client) ([]string, error) {
+	versions := []string{}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(config.BucketName),
+		Prefix:    aws.String(versionsPrefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			version := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), versionsPrefix), "/")
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
+// deleteVersion removes every object uploaded as a part of the given version
+func deleteVersion(ctx context.Context, client *s3.Client, version string) error {
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(config.BucketName),
+		Prefix:  aws.String(versionPrefix(version)),
+		MaxKeys: maxDeleteBatch,
+	})
+
+	deleted := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+
+		output, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(config.BucketName),
+			Delete: &types.Delete{Objects: objects, Quiet: true},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			return fmt.Errorf("could not delete %d objects, first error: %s", len(output.Errors), aws.ToString(output.Errors[0].Message))
+		}
+		deleted += len(objects)
+	}
+
+	log.Printf("[versioning] Deleted version %s (%d objects)\n", version, deleted)
+	return nil
+}
+
+// versionsToDelete returns the versions due for garbage collection: ones replaced longer than the retention
+// period ago, and ones never published - abandoned uploads - started longer than it ago. The current version
+// and the one it replaced are always kept, for clients which haven't read the new pointer yet.
+func versionsToDelete(versions []string, pointer Pointer, now time.Time, retention time.Duration) []string {
+	replacedAt := map[string]string{}
+	for _, replaced := range pointer.Replaced {
+		replacedAt[replaced.Version] = replaced.ReplacedAt
+	}
+
+	toDelete := []string{}
+	for _, version := range versions {
+		if version == pointer.Version || (len(pointer.Replaced) > 0 && version == pointer.Replaced[0].Version) {
+			continue
+		}
+
+		since, err := time.Parse(time.RFC3339, replacedAt[version])
+		if err != nil {
+			since, err = time.Parse(versionFormat, version)
+		}
+		if err != nil {
+			log.Printf("[versioning] Skipping garbage collection of '%s' - not a version name: %v\n", version, err)
+			continue
+		}
+
+		if now.Sub(since) >= retention {
+			toDelete = append(toDelete, version)
+		}
+	}
+	return toDelete
+}
+
+// garbageCollectVersions deletes versions due for garbage collection according to the published pointer
+func garbageCollectVersions(ctx context.Context, client *s3.Client, pointer Pointer, retention time.Duration) error {
+	versions, err := publishedVersions(ctx, client)
+	if err != nil {
+		return fmt.Errorf("could not list published versions: %w", err)
+	}
+
+	for _, version := range versionsToDelete(versions, pointer, time.Now(), retention) {
+		err = deleteVersion(ctx, client, version)
+		if err != nil {
+			return fmt.Errorf("could not delete version %s: %w", version, err)
+		}
+	}
+
+	return nil
+}
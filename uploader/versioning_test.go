@@ -0,0 +1,86 @@
+package uploader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testRetention = 72 * time.Hour
+
+func TestNewPointerRemembersReplacedVersions(t *testing.T) {
+	now := time.Date(2026, time.January, 10, 12, 0, 0, 0, time.UTC)
+	previous := Pointer{
+		Version:     "20260105T000000Z",
+		Prefix:      "v/20260105T000000Z",
+		PublishedAt: "2026-01-09T00:00:00Z",
+		Replaced: []ReplacedVersion{
+			{Version: "20260101T000000Z", ReplacedAt: "2026-01-09T00:00:00Z"},
+			// Replaced a retention period ago - forgotten
+			{Version: "20251225T000000Z", ReplacedAt: "2026-01-01T00:00:00Z"},
+		},
+	}
+
+	pointer := newPointer("20260109T000000Z", previous, now, testRetention)
+	expected := Pointer{
+		Version:     "20260109T000000Z",
+		Prefix:      "v/20260109T000000Z",
+		PublishedAt: "2026-01-10T12:00:00Z",
+		Replaced: []ReplacedVersion{
+			{Version: "20260105T000000Z", ReplacedAt: "2026-01-10T12:00:00Z"},
+			{Version: "20260101T000000Z", ReplacedAt: "2026-01-09T00:00:00Z"},
+		},
+	}
+	if !reflect.DeepEqual(pointer, expected) {
+		t.Errorf("expected pointer %+v, got %+v", expected, pointer)
+	}
+
+	if first := newPointer("20260109T000000Z", Pointer{}, now, testRetention); len(first.Replaced) != 0 {
+		t.Errorf("expected the first pointer not to replace anything, got %+v", first.Replaced)
+	}
+}
+
+func TestVersionsToDelete(t *testing.T) {
+	now := time.Date(2026, time.January, 10, 12, 0, 0, 0, time.UTC)
+	pointer := Pointer{
+		Version: "20260109T000000Z",
+		Replaced: []ReplacedVersion{
+			// Current until now, but its upload started long before the retention period
+			{Version: "20260101T000000Z", ReplacedAt: "2026-01-10T12:00:00Z"},
+			// Started long ago, replaced recently
+			{Version: "20251220T000000Z", ReplacedAt: "2026-01-09T00:00:00Z"},
+			// Replaced a retention period ago
+			{Version: "20251210T000000Z", ReplacedAt: "2026-01-05T00:00:00Z"},
+		},
+	}
+	versions := []string{
+		"20251210T000000Z",
+		"20251220T000000Z",
+		"20260101T000000Z",
+		// Never published, and abandoned long ago
+		"20251230T000000Z",
+		// Never published, started recently - possibly still uploading
+		"20260110T000000Z",
+		"20260109T000000Z",
+		"not-a-version",
+	}
+
+	toDelete := versionsToDelete(versions, pointer, now, testRetention)
+	if expected := []string{"20251210T000000Z", "20251230T000000Z"}; !reflect.DeepEqual(toDelete, expected) {
+		t.Errorf("expected versions %v to be deleted, got %v", expected, toDelete)
+	}
+}
+
+func TestVersionsToDeleteKeepsThePreviousVersion(t *testing.T) {
+	now := time.Date(2026, time.January, 10, 12, 0, 0, 0, time.UTC)
+	// Replaced longer than the retention period ago, but nothing replaced the current version since
+	pointer := Pointer{
+		Version:  "20260101T000000Z",
+		Replaced: []ReplacedVersion{{Version: "20251201T000000Z", ReplacedAt: "2026-01-01T00:00:00Z"}},
+	}
+
+	toDelete := versionsToDelete([]string{"20251201T000000Z", "20260101T000000Z"}, pointer, now, testRetention)
+	if len(toDelete) != 0 {
+		t.Errorf("expected the current and previous versions to be kept, got %v deleted", toDelete)
+	}
+}